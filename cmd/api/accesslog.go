@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AccessLogTime is the layout of the timestamps of access logs.
+const AccessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogLine matches the lines of access logs in the combined format, or in
+// the common format which lacks the referer and user agent:
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /search?q=go HTTP/1.1" 200 2326 "-" "curl/8.0"
+var accessLogLine = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "((?:[^"\\]|\\.)*)" \S+ \S+`)
+
+// accessLogSource reads the searches of web server access logs, the query
+// being a parameter of the URL of the requests.
+type accessLogSource struct {
+	lines *lineReader
+	param string
+}
+
+func newAccessLogSource(file string, r io.Reader, opts SourceOptions, from Position) *accessLogSource {
+	ret := &accessLogSource{lines: newLineReader(file, r, from), param: "q"}
+	if opts.QueryParam != "" {
+		ret.param = opts.QueryParam
+	}
+	return ret
+}
+
+func (a *accessLogSource) Read() (Hit, error) {
+	raw, line, err := a.lines.next()
+	if err != nil {
+		return Hit{}, err
+	}
+
+	m := accessLogLine.FindSubmatch(raw)
+	if m == nil {
+		return Hit{}, a.lines.error(raw, line, IngestErrorSyntax, errors.New("not an access log line"))
+	}
+	t, err := time.Parse(AccessLogTime, string(m[1]))
+	if err != nil {
+		return Hit{}, a.lines.error(raw, line, IngestErrorDate, err)
+	}
+
+	// the request is "METHOD URI PROTOCOL", or "-" for malformed requests
+	request := strings.Fields(string(m[2]))
+	if len(request) < 2 {
+		return Hit{}, ErrNoQuery
+	}
+	u, err := url.ParseRequestURI(request[1])
+	if err != nil {
+		return Hit{}, ErrNoQuery
+	}
+	q := u.Query().Get(a.param)
+	if q == "" {
+		return Hit{}, ErrNoQuery
+	}
+
+	return Hit{Line: line, Query: q, Time: t.UTC()}, nil
+}
+
+func (a *accessLogSource) Position() Position {
+	return Position{Line: a.lines.lines}
+}
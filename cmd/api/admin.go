@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ReloadResult is returned to the API user when a reload is started.
+type ReloadResult struct {
+	Status  string   `json:"status"`
+	Indexes []string `json:"indexes"`           // indexes being reloaded
+	Loading []string `json:"loading,omitempty"` // indexes already being loaded
+}
+
+// Reload is the handler responsible for the /1/admin/reload route. It rebuilds
+// the trees of the indexes not already being loaded from their TSV files in
+// the background, the current trees being served until the new ones are
+// indexed.
+func (h *Handler) Reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		h.Error(w, r, &Error{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "method not allowed"})
+		return
+	}
+
+	out := ReloadResult{Status: "reloading", Indexes: []string{}}
+	for _, i := range h.indexes() {
+		if err := i.Reload(); err == nil {
+			out.Indexes = append(out.Indexes, i.Name)
+		} else {
+			out.Loading = append(out.Loading, i.Name)
+		}
+	}
+	if len(out.Indexes) == 0 {
+		msg := "index " + strings.Join(out.Loading, ", ") + " is already being loaded"
+		if len(out.Loading) > 1 {
+			msg = "indexes " + strings.Join(out.Loading, ", ") + " are already being loaded"
+		}
+		h.Error(w, r, &Error{Status: http.StatusConflict, Code: CodeReloadInProgress, Message: msg})
+		return
+	}
+
+	h.RequestLogger(r).Info("index reload requested", "indexes", out.Indexes)
+	h.Respond(w, r, out, http.StatusAccepted)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers used to authenticate API calls.
+const (
+	APIKeyHeader        = "X-Algolia-API-Key"
+	ApplicationIDHeader = "X-Algolia-Application-Id"
+)
+
+// ACLs that can be given to API keys.
+const (
+	ACLCount       = "count"
+	ACLPopular     = "popular"
+	ACLListIndexes = "listIndexes"
+	ACLAdmin       = "admin" // grants every other ACL
+)
+
+// Restrictions that can be embedded in secured API keys.
+const (
+	ValidUntilParam       = "validUntil"
+	RestrictIndicesParam  = "restrictIndices"
+	RestrictDateFromParam = "restrictDateFrom"
+	RestrictDateToParam   = "restrictDateTo"
+)
+
+// KeyStore holds the API keys allowed to call the API.
+type KeyStore struct {
+	ApplicationID string    `json:"application_id"`
+	Keys          []*APIKey `json:"keys"`
+
+	byHash map[[sha256.Size]byte]*APIKey
+}
+
+// APIKey is a key allowed to call the API. Indexes can be exact names or
+// prefixes ending with "*"; a key without indexes can query all of them.
+type APIKey struct {
+	Value       string   `json:"value"`
+	Description string   `json:"description"`
+	ACL         []string `json:"acl"`
+	Indexes     []string `json:"indexes"`
+
+	// restrictions of secured keys, which are derived from a parent key
+	parent     *APIKey
+	validUntil time.Time
+	indexes    []string
+	dateFrom   time.Time
+	dateTo     time.Time
+}
+
+// LoadKeys reads the API keys from a JSON file.
+func LoadKeys(file string) (*KeyStore, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ks := new(KeyStore)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(ks); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	if err := ks.init(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return ks, nil
+}
+
+func (ks *KeyStore) init() error {
+	ks.byHash = make(map[[sha256.Size]byte]*APIKey, len(ks.Keys))
+	for i, k := range ks.Keys {
+		if k.Value == "" {
+			return fmt.Errorf("key %d has no value", i)
+		}
+		for _, acl := range k.ACL {
+			if acl != ACLCount && acl != ACLPopular && acl != ACLListIndexes && acl != ACLAdmin {
+				return fmt.Errorf("key %d has unknown acl %s", i, acl)
+			}
+		}
+
+		h := sha256.Sum256([]byte(k.Value))
+		if _, ok := ks.byHash[h]; ok {
+			return fmt.Errorf("key %d is a duplicate", i)
+		}
+		ks.byHash[h] = k
+	}
+
+	return nil
+}
+
+// Authenticate returns the API key matching the value sent by a client. Secured
+// keys are checked against every key of the store except admin ones, which
+// cannot be used to derive secured keys, their relative date restrictions
+// being resolved against now.
+func (ks *KeyStore) Authenticate(value string, now time.Time) (*APIKey, error) {
+	if k, ok := ks.byHash[sha256.Sum256([]byte(value))]; ok {
+		return k, nil
+	}
+
+	// secured keys are base64(hex(hmac) + params)
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) < 2*sha256.Size {
+		return nil, errors.New("invalid API key")
+	}
+
+	mac, err := hex.DecodeString(string(decoded[:2*sha256.Size]))
+	if err != nil {
+		return nil, errors.New("invalid API key")
+	}
+
+	params := string(decoded[2*sha256.Size:])
+	for _, parent := range ks.Keys {
+		if parent.Allows(ACLAdmin) {
+			continue
+		}
+		if hmac.Equal(mac, signParams(parent.Value, params)) {
+			return parent.secured(value, params, now)
+		}
+	}
+
+	return nil, errors.New("invalid API key")
+}
+
+// GenerateSecuredKey returns a secured API key derived from parent and
+// embedding the given restrictions.
+func GenerateSecuredKey(parent string, restrictions url.Values) string {
+	params := restrictions.Encode()
+	mac := hex.EncodeToString(signParams(parent, params))
+	return base64.StdEncoding.EncodeToString([]byte(mac + params))
+}
+
+func signParams(key, params string) []byte {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte(params))
+	return m.Sum(nil)
+}
+
+// secured returns the secured key value derived from k with the restrictions
+// of params, relative dates being resolved against now.
+func (k *APIKey) secured(value, params string, now time.Time) (*APIKey, error) {
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		return nil, errors.New("invalid secured API key restrictions")
+	}
+
+	ret := &APIKey{
+		Value:       value,
+		Description: k.Description,
+		ACL:         k.ACL,
+		Indexes:     k.Indexes,
+		parent:      k,
+	}
+	if v := values.Get(ValidUntilParam); v != "" {
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid " + ValidUntilParam + " restriction")
+		}
+		ret.validUntil = time.Unix(ts, 0)
+	}
+	if v := values.Get(RestrictIndicesParam); v != "" {
+		ret.indexes = strings.Split(v, ",")
+	}
+	// relative dates are resolved when the key is used
+	if v := values.Get(RestrictDateFromParam); v != "" {
+		d, err := parseDate(v, now)
+		if err != nil {
+			return nil, errors.New("invalid " + RestrictDateFromParam + " restriction")
+		}
+		ret.dateFrom = d.Time
+	}
+	if v := values.Get(RestrictDateToParam); v != "" {
+		d, err := parseDate(v, now)
+		if err != nil {
+			return nil, errors.New("invalid " + RestrictDateToParam + " restriction")
+		}
+		ret.dateTo = d.End()
+	}
+
+	return ret, nil
+}
+
+// Allows reports whether the key grants the given ACL.
+func (k *APIKey) Allows(acl string) bool {
+	for _, a := range k.ACL {
+		if a == acl || a == ACLAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsIndex reports whether the key can query the given index.
+func (k *APIKey) AllowsIndex(name string) bool {
+	if len(k.Indexes) > 0 && !matchIndex(k.Indexes, name) {
+		return false
+	}
+
+	return k.indexes == nil || matchIndex(k.indexes, name)
+}
+
+// AllowsDate reports whether the key can query the given period.
+func (k *APIKey) AllowsDate(d DateInfo) bool {
+	if !k.dateFrom.IsZero() && d.Before(k.dateFrom) {
+		return false
+	}
+
+	return k.dateTo.IsZero() || !d.End().After(k.dateTo)
+}
+
+// Expired reports whether a secured key is no longer valid.
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.validUntil.IsZero() && now.After(k.validUntil)
+}
+
+// Root returns the key of the key store a secured key is derived from, k
+// itself if it is not secured.
+func (k *APIKey) Root() *APIKey {
+	for k.parent != nil {
+		k = k.parent
+	}
+	return k
+}
+
+// ID returns an identifier of the key that can be logged, secured keys being
+// prefixed by the identifier of their parent.
+func (k *APIKey) ID() string {
+	h := sha256.Sum256([]byte(k.Value))
+	id := hex.EncodeToString(h[:4])
+	if k.parent != nil {
+		return k.parent.ID() + "/" + id
+	}
+
+	return id
+}
+
+func matchIndex(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if p == name || p == "*" || (strings.HasSuffix(p, "*") && strings.HasPrefix(name, p[:len(p)-1])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const apiKey contextKey = "algolia.apikey"
+
+// GetAPIKey returns the API key used by a request, or nil if the request is
+// not authenticated.
+func GetAPIKey(r *http.Request) *APIKey {
+	k, _ := r.Context().Value(apiKey).(*APIKey)
+	return k
+}
+
+// AuthMiddleware is a middleware checking that requests carry an API key
+// granting the given ACL on the index. Requests are not checked if the handler
+// has no key store.
+func (h *Handler) AuthMiddleware(acl string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Keys == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		value := r.Header.Get(APIKeyHeader)
+		if value == "" {
+			h.Error(w, r, &Error{Status: http.StatusUnauthorized, Code: CodeMissingAPIKey, Message: "missing API key"})
+			return
+		}
+		if h.Keys.ApplicationID != "" && r.Header.Get(ApplicationIDHeader) != h.Keys.ApplicationID {
+			h.Error(w, r, &Error{Status: http.StatusUnauthorized, Code: CodeInvalidApplicationID, Message: "invalid application ID"})
+			return
+		}
+
+		k, err := h.Keys.Authenticate(value, h.referenceTime(r))
+		if err != nil {
+			h.Error(w, r, &Error{Status: http.StatusUnauthorized, Code: CodeInvalidAPIKey, Message: err.Error()})
+			return
+		}
+		if k.Expired(h.now()) {
+			h.Error(w, r, &Error{Status: http.StatusUnauthorized, Code: CodeExpiredAPIKey, Message: "API key expired"})
+			return
+		}
+		if !k.Allows(acl) {
+			h.Error(w, r, &Error{Status: http.StatusForbidden, Code: CodeForbidden, Message: "API key not allowed to access this route"})
+			return
+		}
+		if i := GetIndex(r); i != nil && !k.AllowsIndex(i.Name) {
+			h.Error(w, r, &Error{Status: http.StatusForbidden, Code: CodeForbidden, Message: "API key not allowed to access this index"})
+			return
+		}
+
+		GetRequestInfo(r).Key = k.ID()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKey, k)))
+	})
+}
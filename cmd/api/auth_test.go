@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var sampleKeys = `{
+	"application_id": "APPID",
+	"keys": [
+		{"value": "admin-key", "description": "admin", "acl": ["admin"]},
+		{"value": "search-key", "description": "dashboard", "acl": ["count", "popular"], "indexes": ["default", "dev_*"]},
+		{"value": "count-key", "description": "counter", "acl": ["count"], "indexes": ["other"]}
+	]
+}`
+
+func TestAuthMiddleware(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	if err := ioutil.WriteFile(file, []byte(sampleKeys), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeys(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+		Keys:   keys,
+	}
+	h.Index.Name = "default"
+	count := queryRouteForTests(&h, h.IndexMiddleware(h.AuthMiddleware(ACLCount, h.DateMiddleware(http.HandlerFunc(h.Count)))))
+	reload := h.AuthMiddleware(ACLAdmin, http.HandlerFunc(h.Healthz))
+
+	secured := func(parent string, params map[string]string) string {
+		v := url.Values{}
+		for k, p := range params {
+			v.Set(k, p)
+		}
+		return GenerateSecuredKey(parent, v)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		target  string
+		appID   string
+		key     string
+		want    int
+	}{
+		{"missing key", count, "/1/queries/count/2015", "APPID", "", http.StatusUnauthorized},
+		{"wrong application", count, "/1/queries/count/2015", "OTHER", "search-key", http.StatusUnauthorized},
+		{"unknown key", count, "/1/queries/count/2015", "APPID", "nope", http.StatusUnauthorized},
+		{"allowed", count, "/1/queries/count/2015", "APPID", "search-key", http.StatusOK},
+		{"admin can count", count, "/1/queries/count/2015", "APPID", "admin-key", http.StatusOK},
+		{"admin route", reload, "/1/admin/reload", "APPID", "admin-key", http.StatusOK},
+		{"not admin", reload, "/1/admin/reload", "APPID", "search-key", http.StatusForbidden},
+		{"wrong index", count, "/1/queries/count/2015", "APPID", "count-key", http.StatusForbidden},
+		{
+			"secured key",
+			count, "/1/queries/count/2015-08-03", "APPID",
+			secured("search-key", map[string]string{RestrictDateFromParam: "2015-08", RestrictDateToParam: "2015-08"}),
+			http.StatusOK,
+		},
+		{
+			"secured key outside date range",
+			count, "/1/queries/count/2015", "APPID",
+			secured("search-key", map[string]string{RestrictDateFromParam: "2015-08", RestrictDateToParam: "2015-08"}),
+			http.StatusForbidden,
+		},
+		{
+			"secured key restricted index",
+			count, "/1/queries/count/2015", "APPID",
+			secured("search-key", map[string]string{RestrictIndicesParam: "dev_logs"}),
+			http.StatusForbidden,
+		},
+		{
+			"expired secured key",
+			count, "/1/queries/count/2015", "APPID",
+			secured("search-key", map[string]string{ValidUntilParam: strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}),
+			http.StatusUnauthorized,
+		},
+		{
+			"secured key from admin key",
+			count, "/1/queries/count/2015", "APPID",
+			secured("admin-key", nil),
+			http.StatusUnauthorized,
+		},
+		{
+			"secured key with wrong signature",
+			count, "/1/queries/count/2015", "APPID",
+			secured("unknown-key", nil),
+			http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			r.Header.Set(ApplicationIDHeader, tc.appID)
+			r.Header.Set(APIKeyHeader, tc.key)
+			w := httptest.NewRecorder()
+
+			tc.handler.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("wanted status %d, got %d: %s", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("reference time", func(t *testing.T) {
+		h := h
+		h.Clock = &fakeClock{now: time.Date(2015, 8, 22, 16, 0, 0, 0, time.UTC)}
+		count := queryRouteForTests(&h, h.IndexMiddleware(h.AuthMiddleware(ACLCount, h.DateMiddleware(http.HandlerFunc(h.Count)))))
+		validUntil := strconv.FormatInt(time.Date(2015, 8, 23, 0, 0, 0, 0, time.UTC).Unix(), 10)
+
+		tests := []struct {
+			relativeTo string
+			target     string
+			params     map[string]string
+			want       int
+		}{
+			{RelativeToClock, "/1/queries/count/2015-08-03", map[string]string{RestrictDateFromParam: "now-30d"}, http.StatusOK},
+			{RelativeToClock, "/1/queries/count/2015-07-03", map[string]string{RestrictDateFromParam: "now-30d"}, http.StatusForbidden},
+			{RelativeToClock, "/1/queries/count/2015-08-03", map[string]string{ValidUntilParam: validUntil}, http.StatusOK},
+			// the latest hit is 2015-09-10 11:05:10
+			{RelativeToData, "/1/queries/count/2015-09-10", map[string]string{RestrictDateFromParam: "thismonth"}, http.StatusOK},
+			{RelativeToData, "/1/queries/count/2015-08-03", map[string]string{RestrictDateFromParam: "thismonth"}, http.StatusForbidden},
+		}
+		for _, tc := range tests {
+			h.RelativeTo = tc.relativeTo
+			r := httptest.NewRequest("GET", tc.target, nil)
+			r.Header.Set(ApplicationIDHeader, "APPID")
+			r.Header.Set(APIKeyHeader, secured("search-key", tc.params))
+			w := httptest.NewRecorder()
+
+			count.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("%s %s %v: wanted status %d, got %d: %s", tc.relativeTo, tc.target, tc.params, tc.want, w.Code, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h := h
+		h.Keys = nil
+		w := httptest.NewRecorder()
+		h.AuthMiddleware(ACLAdmin, http.HandlerFunc(h.Healthz)).ServeHTTP(w, httptest.NewRequest("GET", "/1/admin/reload", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("wanted status %d, got %d", http.StatusOK, w.Code)
+		}
+	})
+}
+
+func TestLoadKeys(t *testing.T) {
+	for _, content := range []string{
+		`{"keys": [{"value": ""}]}`,
+		`{"keys": [{"value": "a", "acl": ["write"]}]}`,
+		`{"keys": [{"value": "a"}, {"value": "a"}]}`,
+		`{"keys": [{"value": "a", "unknown": true}]}`,
+	} {
+		file := filepath.Join(t.TempDir(), "keys.json")
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeys(file); err == nil {
+			t.Errorf("loading %s should fail", content)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache is a LRU cache of encoded responses, bounded by the total size
+// of the cached bodies. Entries are tied to the version of the tree they were
+// computed from, and are treated as missing once the tree changes.
+type ResponseCache struct {
+	MaxBytes int
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+// CacheStats gives information on the use of a ResponseCache.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int    `json:"bytes"`
+}
+
+type cacheEntry struct {
+	key     string
+	version uint64
+	body    []byte
+}
+
+// NewResponseCache returns an empty cache holding at most maxBytes of
+// responses.
+func NewResponseCache(maxBytes int) *ResponseCache {
+	return &ResponseCache{
+		MaxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get returns the response cached for key, if it was computed from the given
+// tree version.
+func (c *ResponseCache) Get(key string, version uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	entry := e.Value.(*cacheEntry)
+	if entry.version != version {
+		c.remove(e)
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+	c.stats.Hits++
+	return entry.body, true
+}
+
+// Add caches the response for key, computed from the given tree version.
+// Responses larger than the cache are ignored.
+func (c *ResponseCache) Add(key string, version uint64, body []byte) {
+	entry := &cacheEntry{key: key, version: version, body: body}
+	if entry.size() > c.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	c.stats.Entries++
+	c.stats.Bytes += entry.size()
+	for c.stats.Bytes > c.MaxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *ResponseCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.stats.Entries--
+	c.stats.Bytes -= entry.size()
+}
+
+// size returns the number of bytes accounted for an entry.
+func (e *cacheEntry) size() int {
+	return len(e.key) + len(e.body)
+}
+
+// CacheMiddleware is a middleware serving successful responses of a route from
+// the response cache. Responses are keyed by index, route, period and
+// query parameters such as the size. Requests are not cached if the handler has
+// no cache.
+func (h *Handler) CacheMiddleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index := h.index(r)
+		tree := index.Tree()
+		if h.Cache == nil || tree == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d := GetDateInContext(r)
+		key := strings.Join([]string{
+			index.Name,
+			route,
+			d.UTC().Format(time.RFC3339),
+			d.To.UTC().Format(time.RFC3339),
+			r.URL.Query().Encode(),
+		}, "\n")
+
+		version := tree.Version()
+		if body, ok := h.Cache.Get(key, version); ok {
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(http.StatusOK)
+			if _, err := w.Write(body); err != nil {
+				h.RequestLogger(r).Warn("failed to return response to user", "err", err)
+			}
+			return
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		cw := &cacheWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		if cw.status == http.StatusOK {
+			h.Cache.Add(key, version, cw.body)
+		}
+	})
+}
+
+// cacheWriter records the body of a response while sending it.
+type cacheWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (c *cacheWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.body = append(c.body, b...)
+	return c.ResponseWriter.Write(b)
+}
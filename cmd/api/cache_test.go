@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	c := NewResponseCache(10)
+	c.Add("a", 1, []byte("1234")) // 5 bytes with the key
+	c.Add("b", 1, []byte("1234")) // 10 bytes
+
+	// a becomes the most recently used
+	if _, ok := c.Get("a", 1); !ok {
+		t.Fatal("a should be cached")
+	}
+
+	c.Add("c", 1, []byte("12")) // evicts b
+	if _, ok := c.Get("b", 1); ok {
+		t.Error("b should have been evicted")
+	}
+	if body, ok := c.Get("a", 1); !ok || string(body) != "1234" {
+		t.Error("a should still be cached")
+	}
+
+	if _, ok := c.Get("c", 2); ok {
+		t.Error("entries of another tree version should be ignored")
+	}
+	c.Add("d", 1, []byte("this body is too large"))
+
+	want := CacheStats{Hits: 2, Misses: 2, Evictions: 1, Entries: 1, Bytes: 5}
+	if got := c.Stats(); got != want {
+		t.Errorf("wanted %+v, got %+v", want, got)
+	}
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+		Cache:  NewResponseCache(1 << 20),
+	}
+	popular := queryRouteForTests(&h, h.DateMiddleware(h.CacheMiddleware("popular", http.HandlerFunc(h.Popular))))
+
+	do := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		popular.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	first := do("/1/queries/popular/2015?size=2")
+	if first.Header().Get("X-Cache") != "MISS" {
+		t.Error("first request should miss the cache")
+	}
+	second := do("/1/queries/popular/2015?size=2")
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Errorf("second request should be served from the cache, got %s", second.Body.String())
+	}
+	if do("/1/queries/popular/2015?size=3").Header().Get("X-Cache") != "MISS" {
+		t.Error("size should be part of the cache key")
+	}
+	if do("/1/queries/popular/2015-08?size=2").Header().Get("X-Cache") != "MISS" {
+		t.Error("date should be part of the cache key")
+	}
+
+	do("/1/queries/popular/2015?size=-1")
+	if do("/1/queries/popular/2015?size=-1").Header().Get("X-Cache") != "MISS" {
+		t.Error("errors should not be cached")
+	}
+
+	h.Index.SetTree(getTreeForTests(t))
+	if do("/1/queries/popular/2015?size=2").Header().Get("X-Cache") != "MISS" {
+		t.Error("a new tree should invalidate the cache")
+	}
+
+	// hits inserted in follow mode change the counts before they are indexed
+	count := queryRouteForTests(&h, h.DateMiddleware(h.CacheMiddleware("count", http.HandlerFunc(h.Count))))
+	w := httptest.NewRecorder()
+	count.ServeHTTP(w, httptest.NewRequest("GET", "/1/queries/count/2015", nil))
+	h.Index.Tree().Insert("followed", time.Date(2015, 9, 10, 11, 5, 11, 0, time.UTC))
+	w = httptest.NewRecorder()
+	count.ServeHTTP(w, httptest.NewRequest("GET", "/1/queries/count/2015", nil))
+	if w.Header().Get("X-Cache") != "MISS" || w.Body.String() != `{"count":8}` {
+		t.Errorf("inserted hits should invalidate the cache, got %s %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// contentEncoding is a compression scheme that can be negotiated with clients
+// through the Accept-Encoding header.
+type contentEncoding struct {
+	name      string
+	newWriter func(w io.Writer) io.WriteCloser
+}
+
+// contentEncodings are the supported encodings, by order of preference:
+// Brotli, whose encoder is vendored, compresses JSON better than gzip.
+var contentEncodings = []contentEncoding{
+	{"br", func(w io.Writer) io.WriteCloser { return brotli.NewWriterLevel(w, brotli.DefaultCompression) }},
+	{"gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+}
+
+// negotiateEncoding returns the encoding preferred by the client among the
+// supported ones, or nil if the response must not be compressed.
+func negotiateEncoding(acceptEncoding string) *contentEncoding {
+	if acceptEncoding == "" {
+		return nil
+	}
+
+	weights := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+		weights[name] = q
+	}
+
+	var best *contentEncoding
+	var bestQ float64
+	for i, e := range contentEncodings {
+		q, ok := weights[e.name]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = &contentEncodings[i], q
+		}
+	}
+
+	return best
+}
+
+// CompressMiddleware is a middleware compressing response bodies of at least
+// minSize bytes with the encoding negotiated with the client.
+func CompressMiddleware(minSize int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: enc, minSize: minSize}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the beginning of a response until it knows whether it
+// is large enough to be compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding *contentEncoding
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	writer  io.WriteCloser // nil if the response is not compressed
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+
+	// responses that cannot have a body are sent right away
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		c.decide(false)
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	if !c.decided {
+		c.buf = append(c.buf, b...)
+		if len(c.buf) < c.minSize {
+			return len(b), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if c.writer != nil {
+		return c.writer.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressing the response if asked to and if it
+// was not already encoded by the handler, then writes the buffered bytes.
+func (c *compressWriter) decide(compress bool) error {
+	if c.decided {
+		return nil
+	}
+	c.decided = true
+
+	hdr := c.Header()
+	if compress && hdr.Get("Content-Encoding") == "" {
+		hdr.Set("Content-Encoding", c.encoding.name)
+		hdr.Del("Content-Length")
+		if etag := hdr.Get("ETag"); strings.HasSuffix(etag, `"`) {
+			// a strong ETag identifies a single representation
+			hdr.Set("ETag", etag[:len(etag)-1]+"-"+c.encoding.name+`"`)
+		}
+		c.writer = c.encoding.newWriter(c.ResponseWriter)
+	}
+
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if c.writer != nil {
+		_, err = c.writer.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+	return err
+}
+
+// Flush sends the data written so far to the client, compressing it even if it
+// is smaller than the minimum size.
+func (c *compressWriter) Flush() {
+	if !c.decided && c.status != 0 {
+		_ = c.decide(true)
+	}
+	if fw, ok := c.writer.(interface{ Flush() error }); ok {
+		_ = fw.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends what is left of the response.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if c.status == 0 && len(c.buf) == 0 {
+			// nothing was written, let the server send its default response
+			return nil
+		}
+		if err := c.decide(false); err != nil {
+			return err
+		}
+	}
+
+	if c.writer != nil {
+		return c.writer.Close()
+	}
+	return nil
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=0.5, br", "br"},
+		{"gzip, br;q=0.5", "gzip"},
+		{"gzip;q=0, br;q=0", ""},
+		{"deflate", ""},
+		{"*", "br"},
+		{"*;q=0.1, br;q=0", "gzip"},
+		{"GZIP ; q=1.0", "gzip"},
+	}
+
+	for _, test := range tests {
+		got := ""
+		if e := negotiateEncoding(test.acceptEncoding); e != nil {
+			got = e.name
+		}
+		if got != test.want {
+			t.Errorf("%q: wanted %q, got %q", test.acceptEncoding, test.want, got)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat("hello world ", 100)
+	handler := CompressMiddleware(100, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		if r.URL.Path == "/small" {
+			io.WriteString(w, "hello")
+			return
+		}
+		// written in pieces around the threshold
+		io.WriteString(w, body[:10])
+		io.WriteString(w, body[10:])
+	}))
+
+	do := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for name, decode := range decoders {
+		t.Run(name, func(t *testing.T) {
+			w := do("/", name)
+			if w.Header().Get("Content-Encoding") != name {
+				t.Fatalf("wanted Content-Encoding %s, got %q", name, w.Header().Get("Content-Encoding"))
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("wrong Vary header %q", w.Header().Get("Vary"))
+			}
+			if etag := w.Header().Get("ETag"); etag != `"abc-`+name+`"` {
+				t.Errorf("the ETag should identify the encoding, got %s", etag)
+			}
+
+			r, err := decode(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != body {
+				t.Errorf("wrong decoded body %q", decoded)
+			}
+		})
+	}
+
+	t.Run("small response", func(t *testing.T) {
+		w := do("/small", "gzip")
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "hello" {
+			t.Errorf("small responses should not be compressed, got %q", w.Body.String())
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("ETag") != `"abc"` {
+			t.Errorf("wrong headers %v", w.Header())
+		}
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		w := do("/", "")
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+			t.Error("the response should not be compressed")
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Error("Vary should be set on uncompressed responses too")
+		}
+	})
+}
+
+func TestCompressedETag(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+	popular := CompressMiddleware(0, queryRouteForTests(&h, h.DateMiddleware(h.ETagMiddleware(http.HandlerFunc(h.Popular)))))
+
+	r := httptest.NewRequest("GET", "/1/queries/popular/2015?size=3", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	popular.ServeHTTP(w, r)
+	etag := w.Header().Get("ETag")
+	if !strings.HasSuffix(etag, `-gzip"`) {
+		t.Fatalf("wanted a gzip ETag, got %q", etag)
+	}
+
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	popular.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("wanted an empty %d response, got %d", http.StatusNotModified, w.Code)
+	}
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Errorf("the 304 response should carry the ETag of the 200 one %s, got %s", etag, got)
+	}
+
+	// a client holding the uncompressed representation keeps its ETag
+	r.Header.Set("If-None-Match", `W/`+strings.TrimSuffix(etag, `-gzip"`)+`"`)
+	w = httptest.NewRecorder()
+	popular.ServeHTTP(w, r)
+	if got := w.Header().Get("ETag"); w.Code != http.StatusNotModified || got != strings.TrimSuffix(etag, `-gzip"`)+`"` {
+		t.Errorf("wanted a %d response with the uncompressed ETag, got %d and %s", http.StatusNotModified, w.Code, got)
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is the prefix of the environment variables configuring the API.
+const envPrefix = "ALGOLIA_API_"
+
+// Config is the configuration of the API. It is read, by increasing order of
+// precedence, from the defaults, the configuration file given with -config,
+// ALGOLIA_API_* environment variables and command line flags.
+type Config struct {
+	Port            uint
+	Files           []string
+	Indexes         []string // name=file
+	Format          string
+	Delimiter       string
+	TimeField       string
+	QueryField      string
+	TimeFormat      string
+	QueryParam      string
+	ErrorPolicy     string
+	Normalize       []string
+	DisplayForms    bool
+	FilterFile      string
+	MaxQueryLength  int
+	BurstLimit      int
+	LazyQuotes      bool
+	FieldsPerRecord int
+	Follow          bool
+	RefreshInterval time.Duration
+	LogFormat       string
+	LogLevel        string
+	ShutdownTimeout time.Duration
+	CacheMaxAge     time.Duration
+	CacheSize       int64
+	CompressMinSize int64
+	KeysFile        string
+	RelativeTo      string
+
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+	CORSMaxAge  time.Duration
+
+	RateLimitCount   RateLimit
+	RateLimitPopular RateLimit
+	RateLimitList    RateLimit
+	RateLimitAdmin   RateLimit
+	RateLimitIP      RateLimit
+}
+
+// DefaultConfig returns the configuration used when nothing is set.
+func DefaultConfig() *Config {
+	return &Config{
+		Port:            8080,
+		Files:           []string{"hn_logs.tsv"},
+		Format:          FormatTSV,
+		TimeFormat:      TimeFormatAuto,
+		QueryParam:      "q",
+		ErrorPolicy:     PolicySkip,
+		FieldsPerRecord: 2,
+		RefreshInterval: DefaultRefreshInterval,
+		LogFormat:       "logfmt",
+		LogLevel:        "info",
+		ShutdownTimeout: 30 * time.Second,
+		CacheMaxAge:     time.Minute,
+		CacheSize:       32 << 20,
+		CompressMinSize: 1 << 10,
+		RelativeTo:      RelativeToClock,
+		CORSMethods:     []string{"GET", "HEAD", "POST", "OPTIONS"},
+		CORSHeaders:     []string{APIKeyHeader, ApplicationIDHeader, RequestIDHeader},
+		CORSMaxAge:      10 * time.Minute,
+	}
+}
+
+// option is a configuration setting that can be set from the configuration
+// file, the environment and the command line.
+type option struct {
+	key   string // key in the configuration file, "table.key" for tables
+	flag  string
+	usage string
+	value configValue
+}
+
+// configValue is a flag.Value that can also be written to a configuration
+// file.
+type configValue interface {
+	flag.Value
+	TOML() string
+}
+
+// env returns the environment variable corresponding to the option.
+func (o option) env() string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(o.key))
+}
+
+// options returns the settings of the configuration, bound to c.
+func (c *Config) options() []option {
+	return []option{
+		{"port", "p", "`port` the http server listen to", (*uintValue)(&c.Port)},
+		{"file", "f", "TSV `file`, directory or glob to read from, - for the standard input, repeated to read several", &repeatedValue{list: &c.Files}},
+		{"indexes", "indexes", "comma-separated `name=file` pairs of additional indexes, served next to the default one read from -f", (*stringsValue)(&c.Indexes)},
+		{"shutdown_timeout", "shutdown-timeout", "maximum `duration` given to in-flight requests to complete on shutdown", (*durationValue)(&c.ShutdownTimeout)},
+		{"input.format", "format", "`format` of the input files: tsv, csv, ndjson, or combined for web server access logs", (*stringValue)(&c.Format)},
+		{"input.delimiter", "delimiter", "field `delimiter` of CSV files, a comma by default, \\t for a tab", (*stringValue)(&c.Delimiter)},
+		{"input.time_field", "time-field", "`column` of the timestamps of TSV and CSV files, numbered from 1 or named in their header, or key of NDJSON files, 1 or time by default", (*stringValue)(&c.TimeField)},
+		{"input.query_field", "query-field", "`column` of the queries of TSV and CSV files, numbered from 1 or named in their header, or key of NDJSON files, 2 or query by default", (*stringValue)(&c.QueryField)},
+		{"input.time_format", "time-format", "`format` of the timestamps of TSV, CSV and NDJSON files: auto to detect it in each file, unix, unix_ms, rfc3339, isolocal, or a Go time layout, timestamps without offset being in UTC", (*stringValue)(&c.TimeFormat)},
+		{"input.query_param", "query-param", "URL `parameter` holding the queries of access logs", (*stringValue)(&c.QueryParam)},
+		{"input.normalize", "normalize", "comma-separated `steps` normalizing the queries before they are indexed, applied in the order given: " + strings.Join(NormalizeSteps, ", "), (*stringsValue)(&c.Normalize)},
+		{"input.display_forms", "display-forms", "return the normalized popular queries in their most frequent original form", (*boolValue)(&c.DisplayForms)},
+		{"input.filter_file", "filter-file", "`file` of rules allowing or blocking queries at ingestion, one per line: allow or block, then a query or a /regexp/", (*stringValue)(&c.FilterFile)},
+		{"input.max_query_length", "max-query-length", "maximum `length` in characters of the queries indexed, 0 for no limit", (*intValue)(&c.MaxQueryLength)},
+		{"input.burst_limit", "burst-limit", "maximum `number` of hits of a query indexed per second, the others being filtered as automated traffic, 0 for no limit", (*intValue)(&c.BurstLimit)},
+		{"input.error_policy", "on-error", "`policy` applied to malformed lines of the input files: skip, fail, or quarantine to write them to a .rejects file next to the input", (*stringValue)(&c.ErrorPolicy)},
+		{"input.lazy_quotes", "lazy-quotes", "allow quotes in unquoted fields and non-doubled quotes in quoted fields of the input files", (*boolValue)(&c.LazyQuotes)},
+		{"input.fields_per_record", "fields-per-record", "`number` of fields of the lines of the input files, 0 for the number of the first line, -1 for any", (*intValue)(&c.FieldsPerRecord)},
+		{"input.follow", "follow", "keep reading the uncompressed input files after their end, inserting the lines appended to them in the served tree", (*boolValue)(&c.Follow)},
+		{"input.refresh_interval", "refresh-interval", "`interval` at which the popularity of the followed files is refreshed", (*durationValue)(&c.RefreshInterval)},
+		{"cache.max_age", "cache-max-age", "`duration` for which clients and proxies can cache responses", (*durationValue)(&c.CacheMaxAge)},
+		{"cache.size", "cache-size", "maximum `size` of the in-process response cache, e.g. 64MB, 0 to disable it", (*byteSizeValue)(&c.CacheSize)},
+		{"compression.min_size", "compress-min-size", "minimum `size` of the responses compressed with gzip or deflate, -1 to disable compression", (*byteSizeValue)(&c.CompressMinSize)},
+		{"dates.relative_to", "relative-to", "reference `time` of relative dates such as now-1h, clock for the current time or data for the latest hit of the index", (*stringValue)(&c.RelativeTo)},
+		{"log.format", "log-format", "log `format`, json or logfmt", (*stringValue)(&c.LogFormat)},
+		{"log.level", "log-level", "minimum log `level`, debug, info, warn or error", (*stringValue)(&c.LogLevel)},
+		{"auth.keys_file", "keys-file", "JSON `file` of the API keys, authentication being disabled if empty", (*stringValue)(&c.KeysFile)},
+		{"cors.allowed_origins", "cors-origins", "comma-separated `origins` allowed to make cross-origin requests, * for any, CORS being disabled if empty", (*stringsValue)(&c.CORSOrigins)},
+		{"cors.allowed_methods", "cors-methods", "comma-separated `methods` allowed in cross-origin requests", (*stringsValue)(&c.CORSMethods)},
+		{"cors.allowed_headers", "cors-headers", "comma-separated request `headers` allowed in cross-origin requests", (*stringsValue)(&c.CORSHeaders)},
+		{"cors.max_age", "cors-max-age", "`duration` for which browsers can cache preflight responses", (*durationValue)(&c.CORSMaxAge)},
+		{"ratelimit.count", "ratelimit-count", "`rate` limit of the count route per client, e.g. 10/s:20 for 10 requests per second with bursts of 20", (*rateLimitValue)(&c.RateLimitCount)},
+		{"ratelimit.popular", "ratelimit-popular", "`rate` limit of the popular route per client", (*rateLimitValue)(&c.RateLimitPopular)},
+		{"ratelimit.list_indexes", "ratelimit-list-indexes", "`rate` limit of the route listing the indexes per client", (*rateLimitValue)(&c.RateLimitList)},
+		{"ratelimit.admin", "ratelimit-admin", "`rate` limit of the admin routes per client", (*rateLimitValue)(&c.RateLimitAdmin)},
+		{"ratelimit.ip", "ratelimit-ip", "`rate` limit of the requests of each IP address to the authenticated routes, applied before authentication", (*rateLimitValue)(&c.RateLimitIP)},
+	}
+}
+
+// Validate checks that the configuration can be used to start the API.
+func (c *Config) Validate() error {
+	var errs []string
+	if c.Port == 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("port %d is out of range", c.Port))
+	}
+	if len(c.Files) == 0 || slices.Contains(c.Files, "") {
+		errs = append(errs, "file cannot be empty")
+	}
+	names := map[string]bool{DefaultIndex: true}
+	for _, spec := range c.Indexes {
+		name, _, err := parseIndexSpec(spec)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if names[name] {
+			errs = append(errs, "index "+name+" is defined twice")
+		}
+		names[name] = true
+	}
+	if c.RelativeTo != RelativeToClock && c.RelativeTo != RelativeToData {
+		errs = append(errs, "dates.relative_to must be "+RelativeToClock+" or "+RelativeToData)
+	}
+	if !slices.Contains(Formats, c.Format) {
+		errs = append(errs, "input.format must be one of "+strings.Join(Formats, ", "))
+	}
+	if _, err := parseDelimiter(c.Delimiter); err != nil {
+		errs = append(errs, "input.delimiter: "+err.Error())
+	}
+	if c.Format == FormatTSV || c.Format == FormatCSV {
+		if err := validColumn(c.TimeField); err != nil {
+			errs = append(errs, "input.time_field: "+err.Error())
+		}
+		if err := validColumn(c.QueryField); err != nil {
+			errs = append(errs, "input.query_field: "+err.Error())
+		}
+	}
+	if err := validTimeFormat(c.TimeFormat); err != nil {
+		errs = append(errs, "input.time_format: "+err.Error())
+	}
+	if c.Format == FormatCombined && c.QueryParam == "" {
+		errs = append(errs, "input.query_param cannot be empty")
+	}
+	if _, err := NewNormalizer(c.Normalize); err != nil {
+		errs = append(errs, "input.normalize: "+err.Error())
+	}
+	if c.MaxQueryLength < 0 {
+		errs = append(errs, "input.max_query_length cannot be negative")
+	}
+	if c.BurstLimit < 0 {
+		errs = append(errs, "input.burst_limit cannot be negative")
+	}
+	if c.ErrorPolicy != PolicySkip && c.ErrorPolicy != PolicyFail && c.ErrorPolicy != PolicyQuarantine {
+		errs = append(errs, "input.error_policy must be "+PolicySkip+", "+PolicyFail+" or "+PolicyQuarantine)
+	}
+	if c.FieldsPerRecord == 1 {
+		errs = append(errs, "input.fields_per_record cannot be 1, lines having a date and a query")
+	}
+	if c.RefreshInterval <= 0 {
+		errs = append(errs, "input.refresh_interval must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, "shutdown_timeout must be positive")
+	}
+	if c.CacheMaxAge < 0 {
+		errs = append(errs, "cache.max_age cannot be negative")
+	}
+	if c.CORSMaxAge < 0 {
+		errs = append(errs, "cors.max_age cannot be negative")
+	}
+	if c.CacheSize < 0 {
+		errs = append(errs, "cache.size cannot be negative")
+	}
+	if _, err := NewLogger(io.Discard, c.LogFormat, c.LogLevel); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// parseIndexSpec parses an index given as name=file.
+func parseIndexSpec(spec string) (name, file string, err error) {
+	i := strings.Index(spec, "=")
+	if i < 0 {
+		return "", "", fmt.Errorf("index %q should be written name=file", spec)
+	}
+
+	name, file = strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if !validIndexName.MatchString(name) {
+		return "", "", fmt.Errorf("invalid index name %q", name)
+	}
+	if file == "" {
+		return "", "", fmt.Errorf("index %s has no file", name)
+	}
+
+	return name, file, nil
+}
+
+// WriteTOML writes the configuration to w in the configuration file format.
+func (c *Config) WriteTOML(w io.Writer) error {
+	var table string
+	for _, o := range c.options() {
+		key := o.key
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			if t := key[:i]; t != table {
+				table = t
+				if _, err := fmt.Fprintf(w, "\n[%s]\n", table); err != nil {
+					return err
+				}
+			}
+			key = key[i+1:]
+		}
+
+		if _, err := fmt.Fprintf(w, "%s = %s\n", key, o.value.TOML()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig returns the configuration built from the command line arguments
+// and the environment, as returned by os.LookupEnv. The second returned value
+// reports whether the -print-config flag is set.
+func LoadConfig(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, bool, error) {
+	// Parse the command line a first time to find the configuration file and
+	// report flag errors early.
+	var file string
+	var printConfig bool
+	fs := newFlagSet(name, DefaultConfig(), &file, &printConfig)
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	c := DefaultConfig()
+	if file != "" {
+		if err := c.readFile(file); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, o := range c.options() {
+		if v, ok := lookupEnv(o.env()); ok {
+			if err := o.value.Set(v); err != nil {
+				return nil, false, fmt.Errorf("invalid value %q for %s: %v", v, o.env(), err)
+			}
+		}
+	}
+
+	// Flags set on the command line override everything else.
+	if err := newFlagSet(name, c, &file, &printConfig).Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	return c, printConfig, c.Validate()
+}
+
+func newFlagSet(name string, c *Config, file *string, printConfig *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(file, "config", "", "configuration `file` (TOML)")
+	fs.BoolVar(printConfig, "print-config", false, "print the effective configuration and exit")
+	for _, o := range c.options() {
+		fs.Var(o.value, o.flag, o.usage+" ("+o.env()+")")
+	}
+
+	return fs
+}
+
+// readFile applies the settings of a configuration file.
+func (c *Config) readFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values, err := parseTOML(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	for _, o := range c.options() {
+		vs, ok := values[o.key]
+		if !ok {
+			continue
+		}
+		delete(values, o.key)
+
+		if l, ok := o.value.(listValue); ok {
+			l.SetList(vs)
+			continue
+		}
+		for _, v := range vs {
+			if err := o.value.Set(v); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %s: %v", file, v, o.key, err)
+			}
+		}
+	}
+
+	for k := range values {
+		return fmt.Errorf("%s: unknown setting %s", file, k)
+	}
+
+	return nil
+}
+
+// listValue is implemented by values set at once from a configuration file
+// array.
+type listValue interface {
+	SetList([]string)
+}
+
+type stringValue string
+
+func (s *stringValue) Set(v string) error { *s = stringValue(v); return nil }
+func (s *stringValue) String() string     { return string(*s) }
+func (s *stringValue) TOML() string       { return tomlString(string(*s)) }
+
+type uintValue uint
+
+func (u *uintValue) Set(v string) error {
+	n, err := strconv.ParseUint(v, 10, 0)
+	if err != nil {
+		return errors.New("not an unsigned integer")
+	}
+
+	*u = uintValue(n)
+	return nil
+}
+func (u *uintValue) String() string { return strconv.FormatUint(uint64(*u), 10) }
+func (u *uintValue) TOML() string   { return u.String() }
+
+type intValue int
+
+func (i *intValue) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return errors.New("not an integer")
+	}
+
+	*i = intValue(n)
+	return nil
+}
+func (i *intValue) String() string { return strconv.Itoa(int(*i)) }
+func (i *intValue) TOML() string   { return i.String() }
+
+type boolValue bool
+
+func (b *boolValue) Set(v string) error {
+	t, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.New("not a boolean")
+	}
+
+	*b = boolValue(t)
+	return nil
+}
+func (b *boolValue) String() string   { return strconv.FormatBool(bool(*b)) }
+func (b *boolValue) TOML() string     { return b.String() }
+func (b *boolValue) IsBoolFlag() bool { return true }
+
+type durationValue time.Duration
+
+func (d *durationValue) Set(v string) error {
+	t, err := time.ParseDuration(v)
+	if err != nil {
+		return errors.New("not a duration")
+	}
+
+	*d = durationValue(t)
+	return nil
+}
+func (d *durationValue) String() string { return time.Duration(*d).String() }
+func (d *durationValue) TOML() string   { return tomlString(d.String()) }
+
+type rateLimitValue RateLimit
+
+func (l *rateLimitValue) Set(v string) error {
+	rl, err := ParseRateLimit(v)
+	if err != nil {
+		return err
+	}
+
+	*l = rateLimitValue(rl)
+	return nil
+}
+func (l *rateLimitValue) String() string { return RateLimit(*l).String() }
+func (l *rateLimitValue) TOML() string   { return tomlString(l.String()) }
+
+type byteSizeValue int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+func (b *byteSizeValue) Set(v string) error {
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(v), u.suffix) {
+			v, unit = strings.TrimSpace(v[:len(v)-len(u.suffix)]), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return errors.New("not a size")
+	}
+
+	*b = byteSizeValue(n * unit)
+	return nil
+}
+func (b *byteSizeValue) String() string {
+	for _, u := range byteUnits {
+		if int64(*b) != 0 && int64(*b)%u.size == 0 {
+			return strconv.FormatInt(int64(*b)/u.size, 10) + u.suffix
+		}
+	}
+	return "0"
+}
+func (b *byteSizeValue) TOML() string { return tomlString(b.String()) }
+
+type stringsValue []string
+
+func (s *stringsValue) Set(v string) error {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*s = list
+	return nil
+}
+func (s *stringsValue) SetList(v []string) {
+	*s = nil
+	for _, item := range v {
+		*s = append(*s, item)
+	}
+}
+func (s *stringsValue) String() string { return strings.Join(*s, ",") }
+func (s *stringsValue) TOML() string {
+	items := make([]string, len(*s))
+	for i, item := range *s {
+		items[i] = tomlString(item)
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// repeatedValue is a list set by repeating its flag. Its first occurrence
+// replaces the value set by the defaults, the configuration file or the
+// environment.
+type repeatedValue struct {
+	list *[]string
+	set  bool
+}
+
+func (r *repeatedValue) Set(v string) error {
+	if !r.set {
+		*r.list = nil
+		r.set = true
+	}
+	*r.list = append(*r.list, v)
+	return nil
+}
+func (r *repeatedValue) SetList(v []string) {
+	*r.list = append([]string(nil), v...)
+}
+func (r *repeatedValue) String() string {
+	if r.list == nil {
+		return ""
+	}
+	return strings.Join(*r.list, ",")
+}
+func (r *repeatedValue) TOML() string {
+	return (*stringsValue)(r.list).TOML()
+}
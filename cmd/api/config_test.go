@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "api.toml")
+	err := ioutil.WriteFile(file, []byte(`# API configuration
+port = 9000
+file = "from-file.tsv" # trailing comment
+shutdown_timeout = "5s"
+
+[log]
+format = "json"
+level = "warn"
+
+[cors]
+allowed_origins = [
+  "https://dashboard.example.com",
+  "http://localhost:3000",
+]
+allowed_methods = ["GET"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := func(vars map[string]string) func(string) (string, bool) {
+		return func(k string) (string, bool) {
+			v, ok := vars[k]
+			return v, ok
+		}
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		c, printConfig, err := LoadConfig("api", nil, env(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printConfig {
+			t.Error("print-config should not be set")
+		}
+		if !reflect.DeepEqual(c, DefaultConfig()) {
+			t.Errorf("wanted default configuration, got %+v", c)
+		}
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		c, _, err := LoadConfig("api", []string{"-config", file, "-log-level", "debug", "-lazy-quotes"}, env(map[string]string{
+			"ALGOLIA_API_FILE":                    "from-env.tsv",
+			"ALGOLIA_API_LOG_LEVEL":               "error",
+			"ALGOLIA_API_CORS_ALLOWED_METHODS":    "GET, OPTIONS",
+			"ALGOLIA_API_INPUT_FIELDS_PER_RECORD": "-1",
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := *DefaultConfig()
+		want.Port = 9000
+		want.Files = []string{"from-env.tsv"}
+		want.LogFormat = "json"
+		want.LogLevel = "debug"
+		want.ShutdownTimeout = 5 * time.Second
+		want.CORSOrigins = []string{"https://dashboard.example.com", "http://localhost:3000"}
+		want.CORSMethods = []string{"GET", "OPTIONS"}
+		want.LazyQuotes = true
+		want.FieldsPerRecord = -1
+		if !reflect.DeepEqual(*c, want) {
+			t.Errorf("wanted %+v, got %+v", want, *c)
+		}
+	})
+
+	t.Run("repeated file flag", func(t *testing.T) {
+		c, _, err := LoadConfig("api", []string{"-config", file, "-f", "a.tsv", "-f", "logs/*.gz"}, env(map[string]string{"ALGOLIA_API_FILE": "from-env.tsv"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a.tsv", "logs/*.gz"}; !reflect.DeepEqual(c.Files, want) {
+			t.Errorf("wanted files %v, got %v", want, c.Files)
+		}
+	})
+
+	failures := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"invalid flag", []string{"-p", "http"}, nil, "not an unsigned integer"},
+		{"invalid env", nil, map[string]string{"ALGOLIA_API_SHUTDOWN_TIMEOUT": "soon"}, "ALGOLIA_API_SHUTDOWN_TIMEOUT"},
+		{"validation", []string{"-p", "70000", "-log-format", "xml"}, nil, "port 70000 is out of range, unknown log format xml"},
+		{"invalid indexes", []string{"-indexes", "docs=docs.tsv,docs=other.tsv,bad,default=x.tsv"}, nil, `index docs is defined twice, index "bad" should be written name=file, index default is defined twice`},
+		{"invalid relative_to", []string{"-relative-to", "tomorrow"}, nil, "dates.relative_to must be clock or data"},
+		{"invalid input", []string{"-on-error", "ignore", "-fields-per-record", "1", "-refresh-interval", "0s", "-normalize", "trim,stem", "-max-query-length", "-1"}, nil, `input.normalize: unknown normalization step "stem", input.max_query_length cannot be negative, input.error_policy must be skip, fail or quarantine, input.fields_per_record cannot be 1, lines having a date and a query, input.refresh_interval must be positive`},
+		{"invalid format", []string{"-format", "xml", "-delimiter", "ab", "-time-format", "epoch"}, nil, `input.format must be one of tsv, csv, ndjson, combined, input.delimiter: invalid delimiter "ab", input.time_format: invalid time format "epoch"`},
+		{"invalid columns", []string{"-format", "csv", "-time-field", "0", "-query-field", "-2"}, nil, "input.time_field: invalid column 0, columns being numbered from 1, input.query_field: invalid column -2, columns being numbered from 1"},
+		{"missing file", []string{"-config", filepath.Join(dir, "missing.toml")}, nil, "missing.toml"},
+	}
+	for _, tc := range failures {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := LoadConfig("api", tc.args, env(tc.env))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("wanted error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	t.Run("print config", func(t *testing.T) {
+		c, printConfig, err := LoadConfig("api", []string{"-config", file, "-print-config"}, env(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !printConfig {
+			t.Error("print-config should be set")
+		}
+
+		var b bytes.Buffer
+		if err := c.WriteTOML(&b); err != nil {
+			t.Fatal(err)
+		}
+		dumped := filepath.Join(dir, "dumped.toml")
+		if err := ioutil.WriteFile(dumped, b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c2, _, err := LoadConfig("api", []string{"-config", dumped}, env(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c2, c) {
+			t.Errorf("dumped configuration should be read back identically, got %+v", *c2)
+		}
+	})
+}
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string][]string
+		err   string
+	}{
+		{
+			name: "tables and values",
+			input: `a = "x # not a comment"
+b = 'literal\'
+[t.u]
+c = 1_000
+d = true
+e = ["x", 'y',
+     "z"] # array
+`,
+			want: map[string][]string{
+				"a":     {"x # not a comment"},
+				"b":     {`literal\`},
+				"t.u.c": {"1000"},
+				"t.u.d": {"true"},
+				"t.u.e": {"x", "y", "z"},
+			},
+		},
+		{name: "duplicate", input: "a = 1\na = 2", err: "line 2: duplicate key a"},
+		{name: "missing equal", input: "a", err: "line 1: expected key = value"},
+		{name: "bad value", input: "a = yes", err: "line 1: a: invalid value yes"},
+		{name: "unterminated array", input: "a = [1,\n2", err: "line 1: unterminated array"},
+		{name: "array of tables", input: "[[a]]", err: "invalid table header"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseTOML(strings.NewReader(tc.input))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("wanted error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("wanted %v, got %v", tc.want, got)
+			}
+			for k, v := range tc.want {
+				if strings.Join(got[k], "|") != strings.Join(v, "|") {
+					t.Errorf("wanted %s = %v, got %v", k, v, got[k])
+				}
+			}
+		})
+	}
+}
+
+func TestTOMLString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", `"plain"`},
+		{`a "quoted" \ path`, `"a \"quoted\" \\ path"`},
+		{"tab\tnew\nline", `"tab\tnew\nline"`},
+		{"\x1b[0m\a\x7f", `"\u001B[0m\u0007\u007F"`},
+		{"café ✓", `"café ✓"`},
+		{"bad \xff byte", `"bad ` + "�" + ` byte"`},
+	}
+	for _, tc := range tests {
+		got := tomlString(tc.in)
+		if got != tc.want {
+			t.Errorf("%q: wanted %s, got %s", tc.in, tc.want, got)
+		}
+		if back, err := parseTOMLScalar(got); err != nil || (back != tc.in && !strings.Contains(tc.in, "\xff")) {
+			t.Errorf("%s should be read back as %q, got %q, %v", got, tc.in, back, err)
+		}
+	}
+}
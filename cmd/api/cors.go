@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsExposedHeaders are the response headers readable by browser scripts in
+// addition to the CORS-safelisted ones.
+var corsExposedHeaders = []string{
+	"ETag",
+	"Retry-After",
+	RequestIDHeader,
+	"X-Cache",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+}
+
+// CORSPolicy tells which cross-origin requests browsers are allowed to make.
+type CORSPolicy struct {
+	Origins []string // allowed origins, "*" allowing any origin
+	Methods []string
+	Headers []string // allowed request headers
+	MaxAge  time.Duration
+}
+
+// AllowsOrigin reports whether requests from origin are allowed.
+func (p CORSPolicy) AllowsOrigin(origin string) bool {
+	for _, o := range p.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAnyOrigin reports whether the policy allows every origin.
+func (p CORSPolicy) allowsAnyOrigin() bool {
+	for _, o := range p.Origins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware is a middleware adding CORS headers to the responses to
+// allowed origins. Preflight requests are passed to next like the other ones,
+// to be routed to CORSPreflight.
+func CORSMiddleware(p CORSPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && p.AllowsOrigin(origin)
+		anyOrigin := p.allowsAnyOrigin()
+		if len(p.Origins) > 0 && !anyOrigin {
+			hdr.Add("Vary", "Origin")
+		}
+		if allowed {
+			if anyOrigin {
+				hdr.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				hdr.Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+
+		if allowed && r.Method != http.MethodOptions {
+			hdr.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORSPreflight answers the OPTIONS requests, including CORS preflight
+// requests, with a 204 status. It is meant to be set as the Options handler
+// of the router, so that only the paths of existing routes are answered.
+func CORSPreflight(p CORSPolicy) http.Handler {
+	methods := strings.Join(p.Methods, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		origin := r.Header.Get("Origin")
+		if origin != "" && p.AllowsOrigin(origin) && r.Header.Get("Access-Control-Request-Method") != "" {
+			hdr.Add("Vary", "Access-Control-Request-Method")
+			hdr.Add("Vary", "Access-Control-Request-Headers")
+			hdr.Set("Access-Control-Allow-Methods", methods)
+			if len(p.Headers) > 0 {
+				hdr.Set("Access-Control-Allow-Headers", strings.Join(p.Headers, ", "))
+			}
+			if p.MaxAge > 0 {
+				hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+	policy := CORSPolicy{
+		Origins: []string{"https://dashboard.example.com"},
+		Methods: []string{"GET", "OPTIONS"},
+		Headers: []string{APIKeyHeader},
+		MaxAge:  10 * time.Minute,
+	}
+	rt := NewRouter()
+	rt.NotFound = http.HandlerFunc(h.NotFound)
+	rt.Options = CORSPreflight(policy)
+	rt.Handle(http.MethodGet, "/1/queries/count/{date}", h.DateMiddleware(http.HandlerFunc(h.Count)))
+	count := CORSMiddleware(policy, rt)
+
+	doPath := func(method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		count.ServeHTTP(w, r)
+		return w
+	}
+	do := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		return doPath(method, "/1/queries/count/2015", origin, headers)
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		w := do("OPTIONS", "https://dashboard.example.com", map[string]string{
+			"Access-Control-Request-Method":  "GET",
+			"Access-Control-Request-Headers": "x-algolia-api-key",
+		})
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("wanted status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+		}
+		want := map[string]string{
+			"Access-Control-Allow-Origin":  "https://dashboard.example.com",
+			"Access-Control-Allow-Methods": "GET, OPTIONS",
+			"Access-Control-Allow-Headers": APIKeyHeader,
+			"Access-Control-Max-Age":       "600",
+		}
+		for k, v := range want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("wanted %s %q, got %q", k, v, got)
+			}
+		}
+	})
+
+	t.Run("preflight from other origin", func(t *testing.T) {
+		w := do("OPTIONS", "https://evil.example.com", map[string]string{"Access-Control-Request-Method": "GET"})
+		if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("other origins should not be allowed, got status %d and headers %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("plain options", func(t *testing.T) {
+		w := do("OPTIONS", "", nil)
+		if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+			t.Errorf("OPTIONS should not be handled as a date, got status %d and Allow %q", w.Code, w.Header().Get("Allow"))
+		}
+	})
+
+	t.Run("unknown path", func(t *testing.T) {
+		w := doPath("OPTIONS", "/1/unknown", "https://dashboard.example.com", map[string]string{"Access-Control-Request-Method": "GET"})
+		if w.Code != http.StatusNotFound || w.Header().Get("Access-Control-Allow-Methods") != "" {
+			t.Errorf("wanted a %d response without preflight headers, got %d and headers %v", http.StatusNotFound, w.Code, w.Header())
+		}
+	})
+
+	t.Run("actual request", func(t *testing.T) {
+		w := do("GET", "https://dashboard.example.com", nil)
+		if w.Code != http.StatusOK || w.Body.String() != `{"count":7}` {
+			t.Errorf("wrong response %d %s", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "https://dashboard.example.com" || w.Header().Get("Vary") != "Origin" {
+			t.Errorf("wrong CORS headers %v", w.Header())
+		}
+		if w.Header().Get("Access-Control-Expose-Headers") == "" {
+			t.Error("response headers should be exposed")
+		}
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		anyOrigin := CORSMiddleware(CORSPolicy{Origins: []string{"*"}}, http.HandlerFunc(h.Healthz))
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.Header.Set("Origin", "https://other.example.com")
+		w := httptest.NewRecorder()
+		anyOrigin.ServeHTTP(w, r)
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Vary") != "" {
+			t.Errorf("wrong CORS headers %v", w.Header())
+		}
+	})
+}
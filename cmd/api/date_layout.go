@@ -1,10 +1,14 @@
 package main
 
 import (
-	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Time string layouts for parsing.
+// Time string layouts of the periods matching a level of the date tree.
 const (
 	Year   = "2006"
 	Month  = "2006-01"
@@ -14,38 +18,233 @@ const (
 	Second = "2006-01-02 15:04:05"
 )
 
-// GetLayout returns the time layout from a string.
-func GetLayout(s string) (string, error) {
-	var caret, space, points int
-
-	// count relevant characters in the string.
-	for _, char := range s {
-		switch char {
-		case '-':
-			caret++
-		case ' ':
-			space++
-		case ':':
-			points++
-		default:
+// Layouts of the periods spanning several nodes of the date tree, which have
+// no time string layout.
+const (
+	Week     = "YYYY-Www"
+	Quarter  = "YYYY-Qq"
+	Interval = "start..end"
+)
+
+// IntervalSeparator separates the start and end dates of an interval.
+const IntervalSeparator = ".."
+
+// Grammar of the dates accepted in URLs, a subset of ISO 8601.
+var (
+	// 2015, 2015-08, 2015-08-03, 2015-08-03T10, 2015-08-03 10:05:07+02:00...
+	extendedDate = regexp.MustCompile(`^(\d{4})(?:-(\d{2})(?:-(\d{2})(?:[T ](\d{2})(?::(\d{2})(?::(\d{2}))?)?(Z|[+-]\d{2}(?::?\d{2})?)?)?)?)?$`)
+	// 20150803, 20150803T10, 20150803T100507Z...
+	basicDate = regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})(?:T(\d{2})(?:(\d{2})(\d{2})?)?(Z|[+-]\d{2}(?:\d{2})?)?)?$`)
+	// 2015-W32, 2015W32, 2015-W32-1, 2015W321
+	weekDate = regexp.MustCompile(`^(\d{4})-?W(\d{2})(?:-?([1-7]))?$`)
+	// 2015-Q3, 2015Q3
+	quarterDate = regexp.MustCompile(`^(\d{4})-?Q([1-4])$`)
+)
+
+// dateLayouts are the layouts of the extended and basic dates, by number of
+// fields given.
+var dateLayouts = []string{Year, Month, Day, Hour, Minute, Second}
+
+// parseDate parses a date from the URL. Dates are either a period of the
+// ISO 8601 calendar (a year, month, day, hour, minute or second, with an
+// optional offset, in the extended or basic format), an ISO week, a quarter,
+// a date relative to now, or an interval between two such dates whose end is
+// included. Dates without offset are in UTC.
+func parseDate(s string, now time.Time) (DateInfo, error) {
+	if i := strings.Index(s, IntervalSeparator); i >= 0 {
+		return parseInterval(s[:i], s[i+len(IntervalSeparator):], now)
+	}
+
+	if d, ok, err := parseRelativeDate(s, now); ok {
+		return d, err
+	}
+
+	if m := extendedDate.FindStringSubmatch(s); m != nil {
+		return parseCalendarDate(m[1:7], m[7])
+	}
+	if m := basicDate.FindStringSubmatch(s); m != nil {
+		return parseCalendarDate(m[1:7], m[7])
+	}
+	if m := weekDate.FindStringSubmatch(s); m != nil {
+		return parseWeekDate(m[1], m[2], m[3])
+	}
+	if m := quarterDate.FindStringSubmatch(s); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		quarter, _ := strconv.Atoi(m[2])
+		t := time.Date(year, time.Month(3*quarter-2), 1, 0, 0, 0, 0, time.UTC)
+		return DateInfo{Time: t, To: periodEnd(t, Quarter), Layout: Quarter}, nil
+	}
+
+	return DateInfo{}, invalidDate("unknown date format " + s)
+}
+
+// parseInterval parses the interval between the start of from and the end of
+// to.
+func parseInterval(from, to string, now time.Time) (DateInfo, error) {
+	if strings.Contains(to, IntervalSeparator) {
+		return DateInfo{}, invalidDate("an interval has a single " + IntervalSeparator + " separator")
+	}
+
+	start, err := parseDate(from, now)
+	if err != nil {
+		return DateInfo{}, err
+	}
+	end, err := parseDate(to, now)
+	if err != nil {
+		return DateInfo{}, err
+	}
+
+	return newInterval(start, end)
+}
+
+// newInterval returns the interval between the start of start and the end of
+// end.
+func newInterval(start, end DateInfo) (DateInfo, error) {
+	if !end.End().After(start.Time) {
+		return DateInfo{}, invalidDate("interval ends before it starts")
+	}
+
+	return DateInfo{Time: start.Time, To: end.End(), Layout: Interval}, nil
+}
+
+// parseCalendarDate returns the period designated by the fields matched by the
+// extended or basic format, from the year to the second, and the offset.
+func parseCalendarDate(fields []string, offset string) (DateInfo, error) {
+	values := []int{0, 1, 1, 0, 0, 0}
+	n := 0
+	for i, f := range fields {
+		if f == "" {
+			break
 		}
+		values[i], _ = strconv.Atoi(f)
+		n++
 	}
 
-	// find appropriate layout for parsing.
 	switch {
-	case caret == 2 && space == 1 && points == 2:
-		return Second, nil
-	case caret == 2 && space == 1 && points == 1:
-		return Minute, nil
-	case caret == 2 && space == 1:
-		return Hour, nil
-	case caret == 2:
-		return Day, nil
-	case caret == 1:
-		return Month, nil
-	case caret == 0:
-		return Year, nil
+	case values[1] < 1 || values[1] > 12:
+		return DateInfo{}, invalidDate("month out of range")
+	case values[2] < 1 || values[2] > daysIn(values[0], time.Month(values[1])):
+		return DateInfo{}, invalidDate("day out of range")
+	case values[3] > 23:
+		return DateInfo{}, invalidDate("hour out of range")
+	case values[4] > 59:
+		return DateInfo{}, invalidDate("minute out of range")
+	case values[5] > 59:
+		return DateInfo{}, invalidDate("second out of range")
+	}
+
+	loc, err := parseOffset(offset)
+	if err != nil {
+		return DateInfo{}, err
+	}
+
+	t := time.Date(values[0], time.Month(values[1]), values[2], values[3], values[4], values[5], 0, loc)
+	return DateInfo{Time: t, To: periodEnd(t, dateLayouts[n-1]), Layout: dateLayouts[n-1]}, nil
+}
+
+// periodStart returns the start of the period of the given layout containing
+// t, in the location of t. Weeks start on Monday.
+func periodStart(t time.Time, layout string) time.Time {
+	y, m, d := t.Date()
+	switch layout {
+	case Year:
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location())
+	case Quarter:
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, t.Location())
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case Week:
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case Day:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	case Hour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case Minute:
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location())
 	default:
-		return "", errors.New("unknown date format " + s)
+		return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	}
+}
+
+// periodEnd returns the end of the period of the given layout starting at t,
+// which is the beginning of the next year if the layout is Year, the next
+// month if it is Month, and so on.
+func periodEnd(t time.Time, layout string) time.Time {
+	switch layout {
+	case Year:
+		return t.AddDate(1, 0, 0)
+	case Quarter:
+		return t.AddDate(0, 3, 0)
+	case Month:
+		return t.AddDate(0, 1, 0)
+	case Week:
+		return t.AddDate(0, 0, 7)
+	case Day:
+		return t.AddDate(0, 0, 1)
+	case Hour:
+		return t.Add(time.Hour)
+	case Minute:
+		return t.Add(time.Minute)
+	default:
+		return t.Add(time.Second)
+	}
+}
+
+// parseOffset returns the location of a UTC offset, Z or ±hh[[:]mm]. An
+// empty offset is UTC.
+func parseOffset(offset string) (*time.Location, error) {
+	if offset == "" || offset == "Z" {
+		return time.UTC, nil
+	}
+
+	digits := strings.Replace(offset[1:], ":", "", 1)
+	hours, _ := strconv.Atoi(digits[:2])
+	var minutes int
+	if len(digits) > 2 {
+		minutes, _ = strconv.Atoi(digits[2:])
+	}
+	if hours > 23 || minutes > 59 {
+		return nil, invalidDate("offset out of range")
 	}
+
+	seconds := hours*3600 + minutes*60
+	if offset[0] == '-' {
+		seconds = -seconds
+	}
+	if seconds == 0 {
+		return time.UTC, nil
+	}
+	return time.FixedZone(offset, seconds), nil
+}
+
+// parseWeekDate returns the ISO week, or the day of the week if day is set.
+// ISO weeks start on Monday, the first week of a year being the one containing
+// its first Thursday.
+func parseWeekDate(year, week, day string) (DateInfo, error) {
+	y, _ := strconv.Atoi(year)
+	w, _ := strconv.Atoi(week)
+
+	// January 4th is always in the first week
+	t := periodStart(time.Date(y, time.January, 4, 0, 0, 0, 0, time.UTC), Week).AddDate(0, 0, 7*(w-1))
+	if iy, iw := t.ISOWeek(); w < 1 || iy != y || iw != w {
+		return DateInfo{}, invalidDate("week out of range")
+	}
+
+	if day == "" {
+		return DateInfo{Time: t, To: periodEnd(t, Week), Layout: Week}, nil
+	}
+
+	d, _ := strconv.Atoi(day)
+	t = t.AddDate(0, 0, d-1)
+	return DateInfo{Time: t, To: t.AddDate(0, 0, 1), Layout: Day}, nil
+}
+
+// daysIn returns the number of days of a month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// invalidDate returns the error of a date that cannot be parsed.
+func invalidDate(reason string) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeInvalidDate, Param: "date", Message: "Failed to parse date: " + reason}
 }
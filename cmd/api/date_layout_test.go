@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"tpaulmyer/algolia/datetree"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		date   string
+		layout string
+		from   string // RFC 3339, in UTC
+		to     string
+		search string // node searched, empty when searched as a range
+	}{
+		// extended format
+		{"2015", Year, "2015-01-01T00:00:00Z", "2016-01-01T00:00:00Z", "2015"},
+		{"2015-08", Month, "2015-08-01T00:00:00Z", "2015-09-01T00:00:00Z", "2015-08"},
+		{"2015-12", Month, "2015-12-01T00:00:00Z", "2016-01-01T00:00:00Z", "2015-12"},
+		{"2015-08-03", Day, "2015-08-03T00:00:00Z", "2015-08-04T00:00:00Z", "2015-08-03"},
+		{"2016-02-29", Day, "2016-02-29T00:00:00Z", "2016-03-01T00:00:00Z", "2016-02-29"},
+		{"2015-08-03 10", Hour, "2015-08-03T10:00:00Z", "2015-08-03T11:00:00Z", "2015-08-03 10"},
+		{"2015-08-03T10", Hour, "2015-08-03T10:00:00Z", "2015-08-03T11:00:00Z", "2015-08-03 10"},
+		{"2015-08-03 10:05", Minute, "2015-08-03T10:05:00Z", "2015-08-03T10:06:00Z", "2015-08-03 10:05"},
+		{"2015-08-03T10:05", Minute, "2015-08-03T10:05:00Z", "2015-08-03T10:06:00Z", "2015-08-03 10:05"},
+		{"2015-08-03 10:05:07", Second, "2015-08-03T10:05:07Z", "2015-08-03T10:05:08Z", "2015-08-03 10:05:07"},
+		{"2015-08-03T10:05:07", Second, "2015-08-03T10:05:07Z", "2015-08-03T10:05:08Z", "2015-08-03 10:05:07"},
+		{"2015-12-31T23:59:59", Second, "2015-12-31T23:59:59Z", "2016-01-01T00:00:00Z", "2015-12-31 23:59:59"},
+
+		// offsets
+		{"2015-08-03T10:05:07Z", Second, "2015-08-03T10:05:07Z", "2015-08-03T10:05:08Z", "2015-08-03 10:05:07"},
+		{"2015-08-03T10:05:07+02:00", Second, "2015-08-03T08:05:07Z", "2015-08-03T08:05:08Z", "2015-08-03 08:05:07"},
+		{"2015-08-03T10+0200", Hour, "2015-08-03T08:00:00Z", "2015-08-03T09:00:00Z", "2015-08-03 08"},
+		{"2015-08-03T10+02", Hour, "2015-08-03T08:00:00Z", "2015-08-03T09:00:00Z", "2015-08-03 08"},
+		{"2015-08-03 10:05-01:00", Minute, "2015-08-03T11:05:00Z", "2015-08-03T11:06:00Z", "2015-08-03 11:05"},
+		{"2015-08-03T02+03:00", Hour, "2015-08-02T23:00:00Z", "2015-08-03T00:00:00Z", "2015-08-02 23"},
+		{"2015-08-03T00:00-00:00", Minute, "2015-08-03T00:00:00Z", "2015-08-03T00:01:00Z", "2015-08-03 00:00"},
+		{"2015-08-03T00-05:30", Hour, "2015-08-03T05:30:00Z", "2015-08-03T06:30:00Z", ""},
+
+		// basic format
+		{"20150803", Day, "2015-08-03T00:00:00Z", "2015-08-04T00:00:00Z", "2015-08-03"},
+		{"20150803T10", Hour, "2015-08-03T10:00:00Z", "2015-08-03T11:00:00Z", "2015-08-03 10"},
+		{"20150803T1005", Minute, "2015-08-03T10:05:00Z", "2015-08-03T10:06:00Z", "2015-08-03 10:05"},
+		{"20150803T100507Z", Second, "2015-08-03T10:05:07Z", "2015-08-03T10:05:08Z", "2015-08-03 10:05:07"},
+		{"20150803T100507+0200", Second, "2015-08-03T08:05:07Z", "2015-08-03T08:05:08Z", "2015-08-03 08:05:07"},
+
+		// ISO weeks
+		{"2015-W32", Week, "2015-08-03T00:00:00Z", "2015-08-10T00:00:00Z", "2015-W32"},
+		{"2015W32", Week, "2015-08-03T00:00:00Z", "2015-08-10T00:00:00Z", "2015-W32"},
+		{"2015-W01", Week, "2014-12-29T00:00:00Z", "2015-01-05T00:00:00Z", "2015-W01"},
+		{"2015-W53", Week, "2015-12-28T00:00:00Z", "2016-01-04T00:00:00Z", "2015-W53"},
+		{"2020-W01", Week, "2019-12-30T00:00:00Z", "2020-01-06T00:00:00Z", "2020-W01"},
+		{"2015-W32-1", Day, "2015-08-03T00:00:00Z", "2015-08-04T00:00:00Z", "2015-08-03"},
+		{"2015-W32-7", Day, "2015-08-09T00:00:00Z", "2015-08-10T00:00:00Z", "2015-08-09"},
+		{"2015W327", Day, "2015-08-09T00:00:00Z", "2015-08-10T00:00:00Z", "2015-08-09"},
+		{"2015-W01-1", Day, "2014-12-29T00:00:00Z", "2014-12-30T00:00:00Z", "2014-12-29"},
+
+		// quarters
+		{"2015-Q1", Quarter, "2015-01-01T00:00:00Z", "2015-04-01T00:00:00Z", ""},
+		{"2015-Q3", Quarter, "2015-07-01T00:00:00Z", "2015-10-01T00:00:00Z", ""},
+		{"2015Q4", Quarter, "2015-10-01T00:00:00Z", "2016-01-01T00:00:00Z", ""},
+
+		// intervals, including their end
+		{"2015-08-01..2015-08-15", Interval, "2015-08-01T00:00:00Z", "2015-08-16T00:00:00Z", ""},
+		{"2015-08-01..2015-08-31", Interval, "2015-08-01T00:00:00Z", "2015-09-01T00:00:00Z", "2015-08"},
+		{"2015-08-03..2015-08-03", Interval, "2015-08-03T00:00:00Z", "2015-08-04T00:00:00Z", "2015-08-03"},
+		{"2015..2015", Interval, "2015-01-01T00:00:00Z", "2016-01-01T00:00:00Z", "2015"},
+		{"2015..2016", Interval, "2015-01-01T00:00:00Z", "2017-01-01T00:00:00Z", ""},
+		{"2015-08-03..2015-08-09", Interval, "2015-08-03T00:00:00Z", "2015-08-10T00:00:00Z", "2015-W32"},
+		{"2015-W32..2015-W33", Interval, "2015-08-03T00:00:00Z", "2015-08-17T00:00:00Z", ""},
+		{"2015-W32..2015-Q3", Interval, "2015-08-03T00:00:00Z", "2015-10-01T00:00:00Z", ""},
+		{"2015-08-03T10:30+02:00..2015-08-03T12Z", Interval, "2015-08-03T08:30:00Z", "2015-08-03T13:00:00Z", ""},
+		{"20150803T10..20150803T10", Interval, "2015-08-03T10:00:00Z", "2015-08-03T11:00:00Z", "2015-08-03 10"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.date, func(t *testing.T) {
+			d, err := parseDate(tc.date, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Layout != tc.layout {
+				t.Errorf("wanted layout %s, got %s", tc.layout, d.Layout)
+			}
+			if from := d.UTC().Format(time.RFC3339); from != tc.from {
+				t.Errorf("wanted start %s, got %s", tc.from, from)
+			}
+			if to := d.End().UTC().Format(time.RFC3339); to != tc.to {
+				t.Errorf("wanted end %s, got %s", tc.to, to)
+			}
+
+			var search string
+			if s, ok := d.Search(); ok {
+				search = searchString(s)
+			}
+			if search != tc.search {
+				t.Errorf("wanted search %q, got %q", tc.search, search)
+			}
+		})
+	}
+}
+
+func TestParseDateErrors(t *testing.T) {
+	tests := []struct {
+		date string
+		err  string
+	}{
+		{"", "unknown date format"},
+		{"zorglub", "unknown date format"},
+		{"15", "unknown date format"},
+		{"2015-8", "unknown date format"},
+		{"201508", "unknown date format"},
+		{"2015-08-03T", "unknown date format"},
+		{"2015-08-03t10", "unknown date format"},
+		{"2015-08-03+02:00", "unknown date format"},
+		{"20150803 10", "unknown date format"},
+		{"2015-0803", "unknown date format"},
+		{"2015-08-03T10:05:07.5", "unknown date format"},
+		{"2015-08-02 15:04:05-999999999", "unknown date format"},
+		{"2015-W5", "unknown date format"},
+		{"2015-W32-8", "unknown date format"},
+		{"2015-Q5", "unknown date format"},
+		{"2015-00", "month out of range"},
+		{"2015-13", "month out of range"},
+		{"2015-08-00", "day out of range"},
+		{"2015-02-29", "day out of range"},
+		{"2016-02-30", "day out of range"},
+		{"2015-09-31", "day out of range"},
+		{"2015-08-03T24", "hour out of range"},
+		{"2015-08-03T10:60", "minute out of range"},
+		{"2015-08-03T10:05:60", "second out of range"},
+		{"2015-08-03T10+24:00", "offset out of range"},
+		{"2015-08-03T10+02:60", "offset out of range"},
+		{"2015-W00", "week out of range"},
+		{"2015-W54", "week out of range"},
+		{"2014-W53", "week out of range"},
+		{"2015-08-15..2015-08-01", "interval ends before it starts"},
+		{"2015..2016..2017", "single .. separator"},
+		{"2015..", "unknown date format"},
+		{"..2015", "unknown date format"},
+		{"2015-13..2016", "month out of range"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.date, func(t *testing.T) {
+			_, err := parseDate(tc.date, time.Time{})
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("wanted an error, got %v", err)
+			}
+			if e.Code != CodeInvalidDate || e.Param != "date" || !strings.HasPrefix(e.Message, "Failed to parse date: ") || !strings.Contains(e.Message, tc.err) {
+				t.Errorf("wanted an invalid_date error with %q, got %+v", tc.err, e)
+			}
+		})
+	}
+}
+
+func TestDateExpressions(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+
+	tests := []struct {
+		date    string
+		count   int
+		popular string
+	}{
+		{"2015-W32", 3, "Elixir"},
+		{"2015-W34-6", 2, "will_this_test_succed_?"},
+		{"2015-Q3", 7, "will_this_test_succed_?"},
+		{"2015-Q4", 0, ""},
+		{"2015-08-01..2015-08-15", 3, "Elixir"},
+		{"2015-08-22T02+02:00", 2, "will_this_test_succed_?"},
+		{"20150903T0005", 2, "experience"},
+		{"2015-08-22T00:30-01:00..2015-08-23T01+01:00", 2, "SoftLayer"},
+		{"2015?weekday=monday", 2, "Elixir"},
+		{"2015?weekday=4", 4, "experience"},
+		{"2015?weekday=Sun", 1, "SoftLayer"},
+		{"2015?hour=0", 6, "Elixir"},
+		{"2015?hour=11", 1, "hungary"},
+		{"2015?hour=23", 1, "Elixir"},
+		{"2015?hour=5", 0, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.date, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, httptest.NewRequest("GET", "/v1/queries/count/"+tc.date, nil))
+			if want := fmt.Sprintf(`{"count":%d}`, tc.count); w.Code != http.StatusOK || w.Body.String() != want {
+				t.Errorf("wanted %s, got %d %s", want, w.Code, w.Body.String())
+			}
+
+			var out PopularResult
+			w = httptest.NewRecorder()
+			queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, httptest.NewRequest("GET", "/v1/queries/popular/"+tc.date+sep(tc.date)+"size=1", nil))
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			var popular string
+			if len(out.Queries) > 0 {
+				popular = out.Queries[0].Query
+			}
+			if popular != tc.popular {
+				t.Errorf("wanted %q to be the most popular query, got %s", tc.popular, w.Body.String())
+			}
+		})
+	}
+
+	params := []struct {
+		target string
+		param  string
+	}{
+		{"/v1/queries/count/2015?weekday=funday", "weekday"},
+		{"/v1/queries/count/2015?weekday=0", "weekday"},
+		{"/v1/queries/count/2015?hour=24", "hour"},
+		{"/v1/queries/count/2015?hour=noon", "hour"},
+		{"/v1/queries/count/2015?hour=1&weekday=1", "hour"},
+		{"/v1/queries/count/2015-08?weekday=1", "weekday"},
+		{"/v1/queries/count/2015-Q3?hour=1", "hour"},
+	}
+	for _, tc := range params {
+		t.Run(tc.target, func(t *testing.T) {
+			var out APIError
+			w := httptest.NewRecorder()
+			queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, httptest.NewRequest("GET", tc.target, nil))
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusBadRequest || out.Code != CodeInvalidParameter || out.Param != tc.param {
+				t.Errorf("wanted an invalid %s parameter, got %d %+v", tc.param, w.Code, out)
+			}
+		})
+	}
+}
+
+// sep returns the separator of a new parameter of the URL.
+func sep(url string) string {
+	if strings.Contains(url, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+// searchString formats the period of a search like a date in the URL.
+func searchString(s datetree.Search) string {
+	ret := fmt.Sprintf("%04d", s.Year)
+	if s.Week.Valid {
+		return fmt.Sprintf("%s-W%02d", ret, s.Week.Int)
+	}
+	for _, f := range []struct {
+		sep string
+		v   int
+		ok  bool
+	}{
+		{"-", s.Month.Int, s.Month.Valid},
+		{"-", s.Day.Int, s.Day.Valid},
+		{" ", s.Hour.Int, s.Hour.Valid},
+		{":", s.Minute.Int, s.Minute.Valid},
+		{":", s.Second.Int, s.Second.Valid},
+	} {
+		if !f.ok {
+			break
+		}
+		ret += fmt.Sprintf("%s%02d", f.sep, f.v)
+	}
+	return ret
+}
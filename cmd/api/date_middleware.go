@@ -4,47 +4,114 @@ import (
 	"context"
 	"net/http"
 	"time"
+	"tpaulmyer/algolia/datetree"
+	"tpaulmyer/algolia/null"
 )
 
-// DateMiddleware is a middleware responsible from parsing the date in the
-// URL, fetching the corresponding date layout and inserting the information
-// into the context to be used by the handlers.
+// DateMiddleware is a middleware responsible from parsing the date path
+// parameter, or the from and to parameters of the routes without one,
+// fetching the corresponding date layout and inserting the information into
+// the context to be used by the handlers.
 func (h *Handler) DateMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h.Logger.Printf("request received [%s]", r.RequestURI)
-		date, err := GetDateFromURL(r)
-		if err != nil {
-			out := APIError{Error: err.Error()}
-			h.Respond(w, out, http.StatusBadRequest)
-			return
+		var d DateInfo
+		var err error
+		if date := r.PathValue("date"); date != "" {
+			d, err = parseDate(date, h.referenceTime(r))
+		} else {
+			d, err = parseDateParams(r, h.referenceTime(r))
 		}
-
-		// try to parse date in order to check its validity
-		layout, err := GetLayout(date)
 		if err != nil {
-			out := APIError{Error: err.Error()}
-			h.Respond(w, out, http.StatusBadRequest)
+			h.Error(w, r, err)
 			return
 		}
 
-		t, err := time.Parse(layout, date)
-		if err != nil {
-			out := APIError{Error: "Failed to parse date: " + err.Error()}
-			h.Respond(w, out, http.StatusBadRequest)
+		GetRequestInfo(r).Layout = d.Layout
+		if k := GetAPIKey(r); k != nil && !k.AllowsDate(d) {
+			h.Error(w, r, &Error{Status: http.StatusForbidden, Code: CodeForbidden, Param: "date", Message: "API key not allowed to access this date range"})
 			return
 		}
 
-		r = SetDateInContext(DateInfo{Time: t, Layout: layout}, r)
+		r = SetDateInContext(d, r)
 		next.ServeHTTP(w, r)
 	})
 }
 
-// DateInfo represents information on a date.
+// parseDateParams parses the period between the from and to parameters, both
+// included, to being now if it is not set.
+func parseDateParams(r *http.Request, now time.Time) (DateInfo, error) {
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if from == "" {
+		return DateInfo{}, &Error{Status: http.StatusBadRequest, Code: CodeMissingParameter, Param: "from", Message: "no date specified in url or from parameter"}
+	}
+	if to == "" {
+		to = "now"
+	}
+
+	start, err := parseDate(from, now)
+	if err != nil {
+		return DateInfo{}, withParam(err, "from")
+	}
+	end, err := parseDate(to, now)
+	if err != nil {
+		return DateInfo{}, withParam(err, "to")
+	}
+
+	return newInterval(start, end)
+}
+
+// withParam sets the parameter of a date error.
+func withParam(err error, param string) error {
+	if e, ok := err.(*Error); ok {
+		e.Param = param
+	}
+	return err
+}
+
+// DateInfo represents information on a date: the period from its time to To,
+// and the layout it was given in.
 type DateInfo struct {
 	time.Time
+	To     time.Time
 	Layout string
 }
 
+// End returns the end of the period designated by the date, excluded from it.
+func (d DateInfo) End() time.Time {
+	return d.To
+}
+
+// Search returns the search of the date tree node matching the period, and
+// whether there is one. Nodes are in UTC, so that the periods given with an
+// offset, as well as quarters and intervals, only match a node when they are
+// aligned on a calendar level or an ISO week; the other periods are searched as
+// ranges.
+func (d DateInfo) Search() (datetree.Search, bool) {
+	from, to := d.UTC(), d.To.UTC()
+	fields := []int{from.Year(), int(from.Month()), from.Day(), from.Hour(), from.Minute(), from.Second()}
+	for n, layout := range dateLayouts {
+		if start := periodStart(from, layout); !start.Equal(from) || !periodEnd(start, layout).Equal(to) {
+			continue
+		}
+
+		return datetree.Search{
+			Year:   fields[0],
+			Month:  null.Int{Valid: n > 0, Int: fields[1]},
+			Day:    null.Int{Valid: n > 1, Int: fields[2]},
+			Hour:   null.Int{Valid: n > 2, Int: fields[3]},
+			Minute: null.Int{Valid: n > 3, Int: fields[4]},
+			Second: null.Int{Valid: n > 4, Int: fields[5]},
+		}, true
+	}
+
+	if start := periodStart(from, Week); start.Equal(from) && periodEnd(start, Week).Equal(to) {
+		year, week := from.ISOWeek()
+		return datetree.Search{Year: year, Week: null.Int{Valid: true, Int: week}}, true
+	}
+
+	return datetree.Search{}, false
+}
+
 type contextKey string
 
 const date contextKey = "algolia.dateinfo"
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Reference times of relative dates.
+const (
+	RelativeToClock = "clock" // the current time
+	RelativeToData  = "data"  // the latest hit of the index
+)
+
+var (
+	// now, now-1h, now+30m
+	nowDate = regexp.MustCompile(`^now(?:([+-])(\d+)([smhdw]))?$`)
+	// last7d, last24h
+	lastDate = regexp.MustCompile(`^last(\d+)([mhdw])$`)
+)
+
+// relativeUnits are the layouts of the periods of the units of relative dates.
+var relativeUnits = map[string]string{
+	"s": Second,
+	"m": Minute,
+	"h": Hour,
+	"d": Day,
+	"w": Week,
+}
+
+// unitLengths are the lengths of the units of relative dates.
+var unitLengths = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// maxShift bounds the shifts of relative dates, whose durations would
+// overflow otherwise.
+const maxShift = 100 * 366 * 24 * time.Hour
+
+// currentPeriods are the relative dates designating the period containing the
+// reference time.
+var currentPeriods = map[string]string{
+	"today":       Day,
+	"thishour":    Hour,
+	"thisweek":    Week,
+	"thismonth":   Month,
+	"thisquarter": Quarter,
+	"thisyear":    Year,
+}
+
+// parseRelativeDate parses a date relative to now, reporting whether s is a
+// relative date:
+//   - now, the second of the reference time, optionally shifted by a number of
+//     seconds, minutes, hours, days or weeks, such as now-1h, of up to about
+//     a century;
+//   - today, yesterday, thishour, thisweek, thismonth, thisquarter and
+//     thisyear, the periods containing the reference time or the day before;
+//   - lastNm, lastNh, lastNd and lastNw, the N minutes, hours, days or weeks
+//     ending with the current one, such as last7d, bounded likewise.
+//
+// Periods are computed in UTC.
+func parseRelativeDate(s string, now time.Time) (DateInfo, bool, error) {
+	now = now.UTC()
+	if s == "yesterday" {
+		t := periodStart(now, Day).AddDate(0, 0, -1)
+		return DateInfo{Time: t, To: periodEnd(t, Day), Layout: Day}, true, nil
+	}
+	if layout, ok := currentPeriods[s]; ok {
+		t := periodStart(now, layout)
+		return DateInfo{Time: t, To: periodEnd(t, layout), Layout: layout}, true, nil
+	}
+
+	if m := nowDate.FindStringSubmatch(s); m != nil {
+		t := periodStart(now, Second)
+		if m[1] != "" {
+			n, err := strconv.Atoi(m[2])
+			if err != nil || time.Duration(n) > maxShift/unitLengths[m[3]] {
+				return DateInfo{}, true, invalidDate("shift out of range")
+			}
+			if m[1] == "-" {
+				n = -n
+			}
+			t = shift(t, relativeUnits[m[3]], n)
+		}
+		return DateInfo{Time: t, To: periodEnd(t, Second), Layout: Second}, true, nil
+	}
+
+	if m := lastDate.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n < 1 || time.Duration(n) > maxShift/unitLengths[m[2]] {
+			return DateInfo{}, true, invalidDate("number of periods out of range")
+		}
+		layout := relativeUnits[m[2]]
+		current := periodStart(now, layout)
+		return DateInfo{Time: shift(current, layout, 1-n), To: periodEnd(current, layout), Layout: Interval}, true, nil
+	}
+
+	return DateInfo{}, false, nil
+}
+
+// shift moves t by n periods of the given layout.
+func shift(t time.Time, layout string, n int) time.Time {
+	switch layout {
+	case Week:
+		return t.AddDate(0, 0, 7*n)
+	case Day:
+		return t.AddDate(0, 0, n)
+	case Hour:
+		return t.Add(time.Duration(n) * time.Hour)
+	case Minute:
+		return t.Add(time.Duration(n) * time.Minute)
+	default:
+		return t.Add(time.Duration(n) * time.Second)
+	}
+}
+
+// referenceTime returns the time the relative dates of a request are resolved
+// against: the latest hit of the index if relative dates are relative to the
+// data and the index is not empty, the current time otherwise.
+func (h *Handler) referenceTime(r *http.Request) time.Time {
+	if h.RelativeTo == RelativeToData {
+		if tree := h.index(r).Tree(); tree != nil && !tree.Latest().IsZero() {
+			return tree.Latest()
+		}
+	}
+
+	return h.now()
+}
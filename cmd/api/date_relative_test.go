@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRelativeDate(t *testing.T) {
+	// a Saturday
+	now := time.Date(2015, 8, 22, 17, 10, 10, 500, time.FixedZone("", 2*3600))
+
+	tests := []struct {
+		date   string
+		layout string
+		from   string // RFC 3339, in UTC
+		to     string
+	}{
+		{"now", Second, "2015-08-22T15:10:10Z", "2015-08-22T15:10:11Z"},
+		{"now-1h", Second, "2015-08-22T14:10:10Z", "2015-08-22T14:10:11Z"},
+		{"now+30m", Second, "2015-08-22T15:40:10Z", "2015-08-22T15:40:11Z"},
+		{"now-90s", Second, "2015-08-22T15:08:40Z", "2015-08-22T15:08:41Z"},
+		{"now-2d", Second, "2015-08-20T15:10:10Z", "2015-08-20T15:10:11Z"},
+		{"now-1w", Second, "2015-08-15T15:10:10Z", "2015-08-15T15:10:11Z"},
+		{"today", Day, "2015-08-22T00:00:00Z", "2015-08-23T00:00:00Z"},
+		{"yesterday", Day, "2015-08-21T00:00:00Z", "2015-08-22T00:00:00Z"},
+		{"thishour", Hour, "2015-08-22T15:00:00Z", "2015-08-22T16:00:00Z"},
+		{"thisweek", Week, "2015-08-17T00:00:00Z", "2015-08-24T00:00:00Z"},
+		{"thismonth", Month, "2015-08-01T00:00:00Z", "2015-09-01T00:00:00Z"},
+		{"thisquarter", Quarter, "2015-07-01T00:00:00Z", "2015-10-01T00:00:00Z"},
+		{"thisyear", Year, "2015-01-01T00:00:00Z", "2016-01-01T00:00:00Z"},
+		{"last15m", Interval, "2015-08-22T14:56:00Z", "2015-08-22T15:11:00Z"},
+		{"last24h", Interval, "2015-08-21T16:00:00Z", "2015-08-22T16:00:00Z"},
+		{"last7d", Interval, "2015-08-16T00:00:00Z", "2015-08-23T00:00:00Z"},
+		{"last1w", Interval, "2015-08-17T00:00:00Z", "2015-08-24T00:00:00Z"},
+		{"now-1h..now", Interval, "2015-08-22T14:10:10Z", "2015-08-22T15:10:11Z"},
+		{"yesterday..today", Interval, "2015-08-21T00:00:00Z", "2015-08-23T00:00:00Z"},
+		{"2015-W34..thisweek", Interval, "2015-08-17T00:00:00Z", "2015-08-24T00:00:00Z"},
+		{"2015-08-01..today", Interval, "2015-08-01T00:00:00Z", "2015-08-23T00:00:00Z"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.date, func(t *testing.T) {
+			d, err := parseDate(tc.date, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Layout != tc.layout {
+				t.Errorf("wanted layout %s, got %s", tc.layout, d.Layout)
+			}
+			if from := d.UTC().Format(time.RFC3339); from != tc.from {
+				t.Errorf("wanted start %s, got %s", tc.from, from)
+			}
+			if to := d.End().UTC().Format(time.RFC3339); to != tc.to {
+				t.Errorf("wanted end %s, got %s", tc.to, to)
+			}
+		})
+	}
+
+	for _, date := range []string{"Now", "now-", "now-1y", "now-1.5h", "last0d", "last7", "last7y", "tomorrow", "today..yesterday", "now-99999999999999h", "now+9999999w", "last99999999999999m"} {
+		t.Run(date, func(t *testing.T) {
+			if _, err := parseDate(date, now); err == nil {
+				t.Errorf("%s should not be parsed", date)
+			}
+		})
+	}
+}
+
+func TestRelativeDates(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 8, 22, 16, 0, 0, 0, time.UTC)}
+	h := Handler{
+		Logger:      slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:       getIndexForTests(t),
+		CacheMaxAge: time.Minute,
+		Clock:       clock,
+	}
+	count := queryRouteForTests(&h, h.DateMiddleware(h.ETagMiddleware(http.HandlerFunc(h.Count))))
+	do := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		count.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	tests := []struct {
+		relativeTo string
+		target     string
+		count      int
+	}{
+		{RelativeToClock, "/1/queries/count/today", 2},
+		{RelativeToClock, "/1/queries/count/last7d", 2},
+		{RelativeToClock, "/1/queries/count?from=now-24h", 2},
+		{RelativeToClock, "/1/queries/count?from=2015-08-01&to=yesterday", 3},
+		{RelativeToClock, "/1/queries/count?from=thisquarter&to=2015-09-03T00Z", 6},
+		// the latest hit is 2015-09-10 11:05:10
+		{RelativeToData, "/1/queries/count/today", 1},
+		{RelativeToData, "/1/queries/count/thismonth", 4},
+		{RelativeToData, "/1/queries/count?from=now-7d", 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.relativeTo+" "+tc.target, func(t *testing.T) {
+			h.RelativeTo = tc.relativeTo
+			w := do(tc.target)
+			if want := fmt.Sprintf(`{"count":%d}`, tc.count); w.Code != http.StatusOK || w.Body.String() != want {
+				t.Errorf("wanted %s, got %d %s", want, w.Code, w.Body.String())
+			}
+		})
+	}
+	h.RelativeTo = ""
+
+	params := []struct {
+		target string
+		code   string
+		param  string
+	}{
+		{"/1/queries/count", CodeMissingParameter, "from"},
+		{"/1/queries/count?to=today", CodeMissingParameter, "from"},
+		{"/1/queries/count?from=zorglub", CodeInvalidDate, "from"},
+		{"/1/queries/count?from=2015&to=zorglub", CodeInvalidDate, "to"},
+		{"/1/queries/count?from=today&to=2015-08-01", CodeInvalidDate, "date"},
+	}
+	for _, tc := range params {
+		t.Run(tc.target, func(t *testing.T) {
+			var out APIError
+			w := do(tc.target)
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusBadRequest || out.Code != tc.code || out.Param != tc.param {
+				t.Errorf("wanted a %s error on %s, got %d %+v", tc.code, tc.param, w.Code, out)
+			}
+		})
+	}
+
+	t.Run("etag follows the clock", func(t *testing.T) {
+		etag := do("/1/queries/count/today").Header().Get("ETag")
+		if other := do("/1/queries/count/today").Header().Get("ETag"); other != etag {
+			t.Errorf("ETag should be stable, got %s and %s", etag, other)
+		}
+		clock.Advance(24 * time.Hour)
+		w := do("/1/queries/count/today")
+		if w.Header().Get("ETag") == etag || !strings.Contains(w.Body.String(), `"count":1`) {
+			t.Errorf("wanted the count of the next day with a new ETag, got %s", w.Body.String())
+		}
+	})
+}
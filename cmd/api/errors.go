@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error codes of the API. Unlike messages, they are stable and can be matched
+// by clients.
+const (
+	CodeMissingParameter     = "missing_parameter"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeInvalidDate          = "invalid_date"
+	CodeNotFound             = "not_found"
+	CodeIndexNotFound        = "index_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeMissingAPIKey        = "missing_api_key"
+	CodeInvalidAPIKey        = "invalid_api_key"
+	CodeInvalidApplicationID = "invalid_application_id"
+	CodeExpiredAPIKey        = "expired_api_key"
+	CodeForbidden            = "forbidden"
+	CodeRateLimited          = "rate_limited"
+	CodeReloadInProgress     = "reload_in_progress"
+	CodeIndexLoading         = "index_loading"
+	CodeInternal             = "internal_error"
+)
+
+// errorDocs are documentation hints returned with the errors of each code.
+var errorDocs = map[string]string{
+	CodeMissingParameter:     "check the required parameters of the route",
+	CodeInvalidParameter:     "the message gives the expected values of the parameter",
+	CodeInvalidDate:          "dates follow ISO 8601, URL encoded: YYYY[-MM[-DD[Thh[:mm[:ss]]]]][Z|±hh:mm], its basic format YYYYMMDD[Thh[mm[ss]]], ISO weeks YYYY-Www[-D], quarters YYYY-Qn, intervals start..end, or relative dates such as now-1h, today, thisweek or last24h",
+	CodeNotFound:             "routes are /1/indexes/{index}/queries/count/{date} and /1/indexes/{index}/queries/popular/{date}?size={size}",
+	CodeIndexNotFound:        "/1/indexes lists the indexes",
+	CodeMethodNotAllowed:     "the Allow header lists the supported methods",
+	CodeMissingAPIKey:        "send the API key in the " + APIKeyHeader + " header",
+	CodeInvalidAPIKey:        "send a key of the keys file, or a secured key derived from one",
+	CodeInvalidApplicationID: "send the application ID in the " + ApplicationIDHeader + " header",
+	CodeExpiredAPIKey:        "generate a new secured key with a later validUntil",
+	CodeForbidden:            "the ACL, indexes and date restrictions of the key do not cover this request",
+	CodeRateLimited:          "wait for the duration given by the Retry-After header",
+	CodeReloadInProgress:     "wait for the current reload to complete",
+	CodeIndexLoading:         "wait for the duration given by the Retry-After header, /readyz gives the loading progress",
+	CodeInternal:             "report the request ID to the API maintainers",
+}
+
+// Error is an error returned to API users, carrying its HTTP status.
+type Error struct {
+	Status  int
+	Code    string
+	Param   string // name of the offending parameter, if any
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// APIError is used to return a JSON error to the user.
+type APIError struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Param     string `json:"param,omitempty"`
+	Doc       string `json:"doc,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error returns err to the user, with the status and code of err if it is an
+// *Error, and as an internal error otherwise.
+func (h *Handler) Error(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		h.RequestLogger(r).Error("unexpected error", "err", err)
+		e = &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "an internal error occurred"}
+	}
+
+	out := APIError{
+		Error:     e.Message,
+		Code:      e.Code,
+		Param:     e.Param,
+		Doc:       errorDocs[e.Code],
+		RequestID: GetRequestInfo(r).ID,
+	}
+	h.Respond(w, r, out, e.Status)
+}
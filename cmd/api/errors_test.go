@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorResponses(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+	popular := h.AccessLog("popular", queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))))
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string
+		param  string
+	}{
+		{"missing size", "/1/queries/popular/2015", http.StatusBadRequest, CodeMissingParameter, "size"},
+		{"invalid size", "/1/queries/popular/2015?size=lol", http.StatusBadRequest, CodeInvalidParameter, "size"},
+		{"negative size", "/1/queries/popular/2015?size=-1", http.StatusBadRequest, CodeInvalidParameter, "size"},
+		{"unknown layout", "/1/queries/popular/2015-08-02%2015:04:05-9?size=1", http.StatusBadRequest, CodeInvalidDate, "date"},
+		{"invalid date", "/1/queries/popular/2015-13?size=1", http.StatusBadRequest, CodeInvalidDate, "date"},
+		{"unknown route", "/1/queries/popular/2015/08?size=1", http.StatusNotFound, CodeNotFound, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			r.Header.Set(RequestIDHeader, "req-"+tc.code)
+			w := httptest.NewRecorder()
+			popular.ServeHTTP(w, r)
+
+			var out APIError
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tc.status || out.Code != tc.code || out.Param != tc.param {
+				t.Errorf("wanted %d %s on %q, got %d %+v", tc.status, tc.code, tc.param, w.Code, out)
+			}
+			if out.Error == "" || out.Doc == "" || out.RequestID != "req-"+tc.code {
+				t.Errorf("error should have a message, a doc and the request ID, got %+v", out)
+			}
+			if tc.code == CodeInvalidDate {
+				for _, form := range []string{"YYYY[-MM[-DD", "YYYYMMDD", "YYYY-Www", "YYYY-Qn", "start..end", "now-1h", "today"} {
+					if !strings.Contains(out.Doc, form) {
+						t.Errorf("the doc should describe the %s dates, got %q", form, out.Doc)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("untyped error", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.Error(w, httptest.NewRequest("GET", "/", nil), errors.New("disk on fire"))
+		want := `{"error":"an internal error occurred","code":"internal_error","doc":"report the request ID to the API maintainers"}`
+		if w.Code != http.StatusInternalServerError || w.Body.String() != want {
+			t.Errorf("wanted %s, got %d %s", want, w.Code, w.Body.String())
+		}
+	})
+}
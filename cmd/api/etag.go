@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// instanceID identifies the running process in ETags, tree versions being only
+// unique within a process.
+var instanceID = func() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+// ETagMiddleware is a middleware adding ETag, Last-Modified and Cache-Control
+// headers to successful responses, and answering 304 Not Modified to
+// conditional requests when the tree has not changed. ETags are derived from
+// the tree version, the request path and parameters and the period queried.
+func (h *Handler) ETagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tree := h.index(r).Tree()
+		if tree == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		etag := h.etag(tree.Version(), r)
+		modified := tree.LastModified().UTC().Truncate(time.Second)
+		headers := func(hdr http.Header) {
+			hdr.Set("ETag", etag)
+			hdr.Set("Last-Modified", modified.Format(http.TimeFormat))
+			hdr.Set("Cache-Control", h.cacheControl())
+		}
+
+		if current, ok := notModified(r, etag, modified); ok {
+			headers(w.Header())
+			w.Header().Set("ETag", current)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		next.ServeHTTP(&cacheHeaderWriter{ResponseWriter: w, headers: headers}, r)
+	})
+}
+
+// etag returns a strong ETag for the response to r. The period is part of the
+// ETag as relative dates designate a different one over time.
+func (h *Handler) etag(version uint64, r *http.Request) string {
+	d := GetDateInContext(r)
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		instanceID,
+		h.index(r).Name,
+		strconv.FormatUint(version, 10),
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(), // sorted by key
+		d.UTC().Format(time.RFC3339),
+		d.To.UTC().Format(time.RFC3339),
+	}, "\n")))
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// cacheControl returns the Cache-Control header of cacheable responses.
+// Responses to authenticated requests must not be stored by shared caches.
+func (h *Handler) cacheControl() string {
+	scope := "public"
+	if h.Keys != nil {
+		scope = "private"
+	}
+
+	return scope + ", max-age=" + strconv.Itoa(int(h.CacheMaxAge.Seconds()))
+}
+
+// notModified reports whether the conditional headers of r match the current
+// version of the response, returning the ETag of the representation the client
+// has: the one it sent with its encoding suffix, so that the 304 response
+// carries the same ETag as the 200 response it revalidates. If-Modified-Since
+// is ignored when If-None-Match is set.
+func notModified(r *http.Request, etag string, modified time.Time) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" {
+				return etag, true
+			}
+			// If-None-Match uses the weak comparison, under which compressed
+			// representations are equivalent
+			strong := strings.TrimPrefix(candidate, "W/")
+			if trimEncodingSuffix(strong) == etag {
+				return strong, true
+			}
+		}
+		return "", false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return etag, err == nil && !modified.After(ims)
+}
+
+// trimEncodingSuffix removes the suffix added to an ETag by CompressMiddleware.
+func trimEncodingSuffix(etag string) string {
+	for _, e := range contentEncodings {
+		suffix := "-" + e.name + `"`
+		if strings.HasSuffix(etag, suffix) {
+			return etag[:len(etag)-len(suffix)] + `"`
+		}
+	}
+	return etag
+}
+
+// cacheHeaderWriter adds caching headers to successful responses only.
+type cacheHeaderWriter struct {
+	http.ResponseWriter
+	headers     func(http.Header)
+	wroteHeader bool
+}
+
+func (c *cacheHeaderWriter) WriteHeader(code int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if code == http.StatusOK {
+			c.headers(c.Header())
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheHeaderWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETagMiddleware(t *testing.T) {
+	h := Handler{
+		Logger:      slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:       getIndexForTests(t),
+		CacheMaxAge: time.Minute,
+	}
+	popular := queryRouteForTests(&h, h.DateMiddleware(h.ETagMiddleware(http.HandlerFunc(h.Popular))))
+
+	do := func(target string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		popular.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/1/queries/popular/2015?size=3", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || len(etag) != 34 {
+		t.Fatalf("wanted a strong ETag, got status %d and ETag %q", w.Code, etag)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("wrong Cache-Control %q", cc)
+	}
+	modified := w.Header().Get("Last-Modified")
+	if _, err := http.ParseTime(modified); err != nil {
+		t.Errorf("wrong Last-Modified %q", modified)
+	}
+
+	t.Run("same etag", func(t *testing.T) {
+		if other := do("/1/queries/popular/2015?size=3", nil).Header().Get("ETag"); other != etag {
+			t.Errorf("ETag should be stable, got %s and %s", etag, other)
+		}
+	})
+
+	t.Run("parameters change etag", func(t *testing.T) {
+		if other := do("/1/queries/popular/2015?size=4", nil).Header().Get("ETag"); other == etag {
+			t.Error("ETag should depend on parameters")
+		}
+	})
+
+	t.Run("if-none-match", func(t *testing.T) {
+		w := do("/1/queries/popular/2015?size=3", map[string]string{"If-None-Match": `"other", ` + etag})
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("wanted empty %d response, got %d", http.StatusNotModified, w.Code)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Error("ETag should be sent with 304 responses")
+		}
+	})
+
+	t.Run("if-modified-since", func(t *testing.T) {
+		w := do("/1/queries/popular/2015?size=3", map[string]string{"If-Modified-Since": modified})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("wanted status %d, got %d", http.StatusNotModified, w.Code)
+		}
+		w = do("/1/queries/popular/2015?size=3", map[string]string{
+			"If-Modified-Since": modified,
+			"If-None-Match":     `"other"`,
+		})
+		if w.Code != http.StatusOK {
+			t.Errorf("If-None-Match should take precedence, got status %d", w.Code)
+		}
+	})
+
+	t.Run("tree change", func(t *testing.T) {
+		tree := getTreeForTests(t)
+		h.Index.SetTree(tree)
+		w := do("/1/queries/popular/2015?size=3", map[string]string{"If-None-Match": etag})
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Errorf("a new tree should change the ETag, got status %d", w.Code)
+		}
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		w := do("/1/queries/popular/2015?size=-1", nil)
+		if w.Code != http.StatusBadRequest || w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" {
+			t.Errorf("errors should not carry caching headers, got status %d and headers %v", w.Code, w.Header())
+		}
+	})
+}
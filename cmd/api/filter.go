@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Filters rejecting hits at ingestion, by which filtered hits are counted.
+const (
+	FilterBlocklist = "blocklist" // query blocked by a rule of the filter file
+	FilterLength    = "length"    // query longer than the maximum length
+	FilterBurst     = "burst"     // query repeated beyond the burst limit
+)
+
+// Filter rejects the automated traffic and junk queries of input files. Queries
+// allowed by a rule of its file are kept whatever the other filters.
+type Filter struct {
+	allow, block filterRules
+	MaxLength    int // in characters, 0 for no limit
+	BurstLimit   int // of identical queries per second, 0 for no limit
+}
+
+// filterRules matches queries with exact terms or regular expressions.
+type filterRules struct {
+	terms   map[string]bool
+	regexps []*regexp.Regexp
+}
+
+func (r *filterRules) match(query string) bool {
+	if r.terms[query] {
+		return true
+	}
+	for _, re := range r.regexps {
+		if re.MatchString(query) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewFilter returns the filter of the rules of file, which is not read if
+// empty, and of the limits given. It returns nil if nothing is filtered.
+//
+// The file has a rule per line, allow or block followed by an exact query or
+// a regular expression between slashes, blank lines and lines starting with #
+// being ignored:
+//
+//	# automated traffic
+//	block /^[0-9a-f]{32}$/
+//	block test
+//	allow /^show hn:/
+//
+// The filter checks the queries once normalized with n, which may be nil: the
+// exact queries of the rules are normalized with n as well, while regular
+// expressions are matched as written against the normalized queries.
+func NewFilter(file string, n *Normalizer, maxLength, burstLimit int) (*Filter, error) {
+	if file == "" && maxLength <= 0 && burstLimit <= 0 {
+		return nil, nil
+	}
+
+	f := &Filter{MaxLength: maxLength, BurstLimit: burstLimit}
+	if file == "" {
+		return f, nil
+	}
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := f.addRule(text, n); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return f, nil
+}
+
+// addRule adds an allow or block rule to the filter, normalizing its exact
+// query with n.
+func (f *Filter) addRule(text string, n *Normalizer) error {
+	action, pattern, _ := strings.Cut(text, " ")
+	pattern = strings.TrimSpace(pattern)
+	var rules *filterRules
+	switch action {
+	case "allow":
+		rules = &f.allow
+	case "block":
+		rules = &f.block
+	default:
+		return fmt.Errorf("rule %q should start with allow or block", text)
+	}
+	if pattern == "" {
+		return fmt.Errorf("rule %q has no query", text)
+	}
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return err
+		}
+		rules.regexps = append(rules.regexps, re)
+		return nil
+	}
+	if rules.terms == nil {
+		rules.terms = map[string]bool{}
+	}
+	rules.terms[n.Normalize(pattern)] = true
+	return nil
+}
+
+// check returns the filter rejecting a hit, or an empty string if it is kept,
+// counting the hits of its query with c.
+func (f *Filter) check(query string, t time.Time, c *burstCounter) string {
+	if f == nil || f.allow.match(query) {
+		return ""
+	}
+	if f.block.match(query) {
+		return FilterBlocklist
+	}
+	if f.MaxLength > 0 && utf8.RuneCountInString(query) > f.MaxLength {
+		return FilterLength
+	}
+	if f.BurstLimit > 0 && c.add(query, t) > f.BurstLimit {
+		return FilterBurst
+	}
+	return ""
+}
+
+// burstWindow is the number of seconds the counts of a second are kept once
+// the files being read are past it, for the hits slightly out of order.
+const burstWindow = 10
+
+// bursts counts the hits of queries by second, for the files loaded in a tree,
+// which are read in parallel with a counter each. The counts of a second are
+// dropped once every file being read is burstWindow seconds past it, so that
+// they do not depend on how the reads of the files interleave, as long as the
+// hits of each file are in chronological order. Files only start holding the
+// counts back once they are read, the memory staying bounded by the files
+// read at the same time.
+type bursts struct {
+	mu       sync.Mutex
+	hits     map[int64]map[string]int // by second and query
+	counters map[*burstCounter]bool
+}
+
+// burstCounter counts the hits of a file in bursts.
+type burstCounter struct {
+	b       *bursts
+	latest  int64 // second of the latest hit counted
+	started bool  // whether a hit has been counted
+	paused  bool  // whether the file waits for new lines
+}
+
+func newBursts() *bursts {
+	return &bursts{hits: map[int64]map[string]int{}, counters: map[*burstCounter]bool{}}
+}
+
+// counter returns a counter of the hits of a file, to be created before the
+// file is read and closed once it is read. The counts are kept until
+// every counter has counted a hit.
+func (b *bursts) counter() *burstCounter {
+	c := &burstCounter{b: b}
+	b.mu.Lock()
+	b.counters[c] = true
+	b.mu.Unlock()
+	return c
+}
+
+// add counts a hit of query and returns the number of hits of the query in its
+// second.
+func (c *burstCounter) add(query string, t time.Time) int {
+	b := c.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := t.Unix()
+	c.paused = false
+	if !c.started || s > c.latest {
+		c.started, c.latest = true, s
+		b.evict()
+	}
+	hits := b.hits[s]
+	if hits == nil {
+		hits = map[string]int{}
+		b.hits[s] = hits
+	}
+	hits[query]++
+	return hits[query]
+}
+
+// pause stops holding the counts back while the file waits for new lines in
+// follow mode, the lines appended to it being more recent than the ones read.
+func (c *burstCounter) pause() {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	c.paused = true
+	c.b.evict()
+}
+
+// close stops counting the hits of the file.
+func (c *burstCounter) close() {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	delete(c.b.counters, c)
+	c.b.evict()
+}
+
+// evict drops the counts of the seconds every file being read is burstWindow
+// seconds past, or the latest file read past if they all wait for lines.
+func (b *bursts) evict() {
+	var oldest, latest int64
+	reading, paused := false, false
+	for c := range b.counters {
+		switch {
+		case c.paused:
+			if c.started && (!paused || c.latest > latest) {
+				latest, paused = c.latest, true
+			}
+		case !c.started:
+			return
+		case !reading || c.latest < oldest:
+			oldest, reading = c.latest, true
+		}
+	}
+	if !reading {
+		if !paused {
+			return
+		}
+		oldest = latest
+	}
+
+	for s := range b.hits {
+		if s < oldest-burstWindow {
+			delete(b.hits, s)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "filter.txt")
+	rules := "# automated traffic\n" +
+		"block /^[0-9a-f]{32}$/\n" +
+		"block test\n" +
+		"\n" +
+		"  allow /^show hn:/  \n" +
+		"allow a very long but legitimate query\n"
+	if err := ioutil.WriteFile(file, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFilter(file, nil, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC)
+	b := newBursts().counter()
+	tests := []struct {
+		query string
+		time  time.Time
+		want  string
+	}{
+		{"go", second, ""},
+		{"test", second, FilterBlocklist},
+		{"testing", second, ""},
+		{"0123456789abcdef0123456789abcdef", second, FilterBlocklist},
+		{"why is this query so long", second, FilterLength},
+		{"a very long but legitimate query", second, ""},
+		{"go", second.Add(500 * time.Millisecond), ""},
+		{"go", second.Add(900 * time.Millisecond), FilterBurst},
+		{"go", second.Add(time.Second), ""},
+		{"show hn: my project", second, ""},
+		{"show hn: my project", second, ""},
+		{"show hn: my project", second, ""},
+	}
+	for _, tc := range tests {
+		if got := f.check(tc.query, tc.time, b); got != tc.want {
+			t.Errorf("%q at %v: wanted %q, got %q", tc.query, tc.time, tc.want, got)
+		}
+	}
+
+	if f, err := NewFilter("", nil, 0, 0); f != nil || err != nil {
+		t.Errorf("nothing should be filtered, got %+v, %v", f, err)
+	}
+	if (*Filter)(nil).check("test", second, nil) != "" {
+		t.Error("a nil filter should keep every query")
+	}
+}
+
+func TestFilterNormalization(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "filter.txt")
+	rules := "block Test\n" +
+		"block /^Spam/\n" +
+		"block /^ham/\n" +
+		"allow  Show  HN \n"
+	if err := ioutil.WriteFile(file, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewNormalizer([]string{NormalizeCollapse, NormalizeFold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFilter(file, n, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the queries are checked once normalized
+	second := time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC)
+	b := newBursts().counter()
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"TEST", FilterBlocklist},
+		{" Test ", FilterBlocklist},
+		{"SPAM offer", ""},
+		{"HAM offer", FilterBlocklist},
+		{"show   hn", ""},
+	}
+	for _, tc := range tests {
+		if got := f.check(n.Normalize(tc.query), second, b); got != tc.want {
+			t.Errorf("%q: wanted %q, got %q", tc.query, tc.want, got)
+		}
+	}
+	if !f.allow.terms["show hn"] {
+		t.Errorf("the exact queries of the rules should be normalized, got %v", f.allow.terms)
+	}
+}
+
+func TestBursts(t *testing.T) {
+	b := newBursts()
+	a, c := b.counter(), b.counter()
+	start := time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	// the counts are kept while c has not started
+	for s := 0; s < 3*burstWindow; s++ {
+		a.add("go", start.Add(time.Duration(s)*time.Second))
+	}
+	if n := c.add("go", start); n != 2 {
+		t.Errorf("wanted the hits of a file to be counted with the ones of the others, got %d", n)
+	}
+
+	// the seconds every file being read is past are dropped
+	c.add("go", start.Add(3*burstWindow*time.Second))
+	if _, ok := b.hits[start.Unix()]; ok {
+		t.Error("the counts of the seconds read by every file should be dropped")
+	}
+	if n := len(b.hits); n != burstWindow+2 {
+		t.Errorf("wanted the counts of %d seconds, got %d", burstWindow+2, n)
+	}
+
+	// a file waiting for lines does not hold the counts back
+	c.pause()
+	for s := 0; s < 3*burstWindow; s++ {
+		a.add("go", start.Add(time.Duration(4*burstWindow+s)*time.Second))
+	}
+	a.close()
+	c.close()
+	if n := len(b.hits); n != burstWindow+1 {
+		t.Errorf("wanted the counts of %d seconds, got %d", burstWindow+1, n)
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		rules string
+		want  string
+	}{
+		{"block go\nreject rust\n", `filter.txt:2: rule "reject rust" should start with allow or block`},
+		{"allow\n", `filter.txt:1: rule "allow" has no query`},
+		{"# regexp\nblock /a(/\n", "filter.txt:2: error parsing regexp"},
+	}
+	for _, tc := range tests {
+		file := filepath.Join(dir, "filter.txt")
+		if err := ioutil.WriteFile(file, []byte(tc.rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFilter(file, nil, 0, 0); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: wanted error %q, got %v", tc.rules, tc.want, err)
+		}
+	}
+	if _, err := NewFilter(filepath.Join(dir, "missing.txt"), nil, 0, 0); err == nil {
+		t.Error("a missing file should fail")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+	"tpaulmyer/algolia/datetree"
+)
+
+// Default intervals of the follow mode.
+const (
+	DefaultRefreshInterval = 10 * time.Second
+	DefaultPollInterval    = time.Second
+)
+
+// followState is where the load of a file ended, for it to be followed.
+type followState struct {
+	file     string
+	info     os.FileInfo // of the file loaded, to detect its rotation
+	offset   int64
+	position Position
+	bursts   *bursts // of the load, shared with the other files
+}
+
+// startFollowing starts inserting the lines appended to the files loaded into
+// tree, and refreshing its popularity, until StopFollowing is called.
+func (i *Index) startFollowing(tree *datetree.Tree, follows []*followState) {
+	stop := make(chan struct{})
+	i.mu.Lock()
+	i.stopFollowing = stop
+	i.mu.Unlock()
+
+	for _, fs := range follows {
+		i.followers.Add(1)
+		go func(fs *followState) {
+			defer i.followers.Done()
+			i.follow(tree, fs, stop)
+		}(fs)
+	}
+
+	i.followers.Add(1)
+	go func() {
+		defer i.followers.Done()
+		i.refresh(tree, stop)
+	}()
+}
+
+// StopFollowing stops following the files of the index, waiting for the lines
+// being inserted.
+func (i *Index) StopFollowing() {
+	i.mu.Lock()
+	stop := i.stopFollowing
+	i.stopFollowing = nil
+	i.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		i.followers.Wait()
+	}
+}
+
+// follow inserts the lines appended to a file into tree until stop is closed.
+func (i *Index) follow(tree *datetree.Tree, fs *followState, stop <-chan struct{}) {
+	poll := i.Options.PollInterval
+	if poll <= 0 {
+		poll = DefaultPollInterval
+	}
+
+	// the lines appended are more recent than the ones of the other files, the
+	// counts of bursts not being held back by the file until it has new lines
+	c := fs.bursts.counter()
+	defer c.close()
+	c.pause()
+
+	// the counts of the lines read are added to the report of the load whenever
+	// the end of the file is reached
+	report := &IngestReport{Rejected: map[string]int{}}
+	merge := func() {
+		i.addReport(report, stop)
+		*report = IngestReport{Rejected: map[string]int{}}
+	}
+	idle := func() {
+		merge()
+		c.pause()
+	}
+	r := &followReader{name: fs.file, info: fs.info, offset: fs.offset, poll: poll, stop: stop, idle: idle}
+	defer r.Close()
+	if err := r.open(); err != nil {
+		i.Logger.Error("failed to follow file", "index", i.Name, "file", fs.file, "err", err)
+		return
+	}
+	i.Logger.Info("following file", "index", i.Name, "file", fs.file, "offset", r.offset)
+
+	pos := fs.position
+	if r.offset != fs.offset {
+		pos = Position{} // rotated since loaded
+	}
+	var aborted atomic.Bool
+	for {
+		// The reader returns io.EOF once stopped, or when the file has been
+		// rotated or truncated, after which it is read from its start.
+		src, err := NewRecordSource(fs.file, r, i.Options.Source, pos)
+		if err == nil {
+			err = i.ingest(tree, c, fs.file, src, report, &aborted)
+			merge()
+		}
+		if err != nil {
+			i.Logger.Error("stopped following file", "index", i.Name, "file", fs.file, "err", err)
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		i.Logger.Info("following rotated file", "index", i.Name, "file", fs.file)
+		pos = Position{}
+	}
+}
+
+// refresh refreshes the popularity of tree, and the metrics of its size, every
+// refresh interval until stop is closed.
+func (i *Index) refresh(tree *datetree.Tree, stop <-chan struct{}) {
+	interval := i.Options.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if tree.RefreshPopularity() {
+			i.Metrics.SetTreeStats(i.Name, tree.Stats())
+		}
+	}
+}
+
+// followReader reads a growing file, waiting for data at its end. It returns
+// io.EOF once stopped, and when the file is rotated, i.e. replaced by another
+// one, or truncated, after which it reads the new content from its start.
+type followReader struct {
+	name   string
+	info   os.FileInfo
+	offset int64
+	poll   time.Duration
+	stop   <-chan struct{}
+	idle   func() // called before waiting for data, nil if none
+
+	f *os.File
+}
+
+// open opens the file at the offset read up to if it has not been rotated
+// since, at its start otherwise.
+func (r *followReader) open() error {
+	f, err := os.Open(r.name)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if !os.SameFile(fi, r.info) || fi.Size() < r.offset {
+		r.offset = 0
+	}
+	if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f, r.info = f, fi
+	return nil
+}
+
+func (r *followReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.f.Read(b)
+		r.offset += int64(n)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		// At the end of the file, which is reopened if it has been replaced or
+		// truncated. A file being rotated may not exist for a moment.
+		if fi, err := os.Stat(r.name); err == nil && (!os.SameFile(fi, r.info) || fi.Size() < r.offset) {
+			r.f.Close()
+			r.f = nil
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+
+		if r.idle != nil {
+			r.idle()
+		}
+		select {
+		case <-r.stop:
+			return 0, io.EOF
+		case <-time.After(r.poll):
+		}
+	}
+}
+
+// Close closes the file being read.
+func (r *followReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
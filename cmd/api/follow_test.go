@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"tpaulmyer/algolia/datetree"
+	"tpaulmyer/algolia/null"
+)
+
+func TestFollow(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "logs.tsv")
+	if err := ioutil.WriteFile(file, []byte(sampleData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := IngestOptions{
+		Source:          SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}},
+		ErrorPolicy:     PolicyQuarantine,
+		Follow:          true,
+		RefreshInterval: 10 * time.Millisecond,
+		PollInterval:    5 * time.Millisecond,
+	}
+	i := &Index{Name: DefaultIndex, Files: []string{file}, Options: opts, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)), Metrics: NewMetrics()}
+	if err := i.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer i.StopFollowing()
+	loaded := i.Report()
+
+	appendLines := func(t *testing.T, name, lines string) {
+		f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString(lines)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pop := func(query string, count int) datetree.Popularity {
+		return datetree.Popularity{Query: query, Count: count}
+	}
+	// wait waits for the queries of January 2016 to be the ones wanted.
+	january := datetree.Search{Year: 2016, Month: null.Int{Valid: true, Int: 1}, Popularity: 10}
+	wait := func(t *testing.T, want []datetree.Popularity) {
+		deadline := time.Now().Add(5 * time.Second)
+		var got []datetree.Popularity
+		for time.Now().Before(deadline) {
+			if got = i.Tree().Popular(january); reflect.DeepEqual(got, want) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("wanted %v, got %v", want, got)
+	}
+
+	t.Run("append", func(t *testing.T) {
+		appendLines(t, file, "2016-01-10 11:05:11\tnew\n2016-01-10 11:05:12\tnew\nnot a line\n2016-01-10 11:05:13\tpart")
+		wait(t, []datetree.Popularity{pop("new", 2)})
+
+		// the end of a line being written is waited for
+		appendLines(t, file, "ial\n")
+		wait(t, []datetree.Popularity{pop("new", 2), pop("partial", 1)})
+
+		rejects, err := ioutil.ReadFile(file + RejectsSuffix)
+		if err != nil || string(rejects) != "not a line\n" {
+			t.Errorf("wanted the malformed line to be quarantined, got %q, %v", rejects, err)
+		}
+
+		// the lines followed are counted in the report of the load
+		deadline := time.Now().Add(5 * time.Second)
+		r := i.Report()
+		for r.Lines != loaded.Lines+4 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+			r = i.Report()
+		}
+		if r.Lines != loaded.Lines+4 || r.Inserted != loaded.Inserted+3 || len(r.Rejected) != 1 || !reflect.DeepEqual(r.RejectsFiles, []string{file + RejectsSuffix}) {
+			t.Errorf("wanted 4 more lines, 3 more inserted and 1 rejected, got %+v after %+v", r, loaded)
+		}
+		if r.Started != loaded.Started || len(loaded.Rejected) != 0 {
+			t.Errorf("the report of the load should be replaced rather than modified, got %+v", loaded)
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		if err := ioutil.WriteFile(file, []byte("2016-01-11 00:00:00\ttruncated\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wait(t, []datetree.Popularity{pop("new", 2), pop("partial", 1), pop("truncated", 1)})
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		if err := os.Rename(file, file+".1"); err != nil {
+			t.Fatal(err)
+		}
+		appendLines(t, file+".1", "2016-01-12 00:00:00\tbefore rotation\n")
+		appendLines(t, file, "2016-01-12 00:00:00\trotated\n")
+		wait(t, []datetree.Popularity{pop("new", 2), pop("before rotation", 1), pop("partial", 1), pop("rotated", 1), pop("truncated", 1)})
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		old := i.Tree()
+		if err := i.Load(); err != nil {
+			t.Fatal(err)
+		}
+		appendLines(t, file, "2016-01-13 00:00:00\treloaded\n")
+		wait(t, []datetree.Popularity{pop("reloaded", 1), pop("rotated", 1)})
+		if n := old.Count(january); n != 5 {
+			t.Errorf("the previous tree should not be followed anymore, got %d queries", n)
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats of input files.
+const (
+	FormatTSV      = "tsv"      // timestamp and query separated by a tab
+	FormatCSV      = "csv"      // delimiter-separated columns
+	FormatNDJSON   = "ndjson"   // a JSON object per line
+	FormatCombined = "combined" // web server access logs
+)
+
+// Formats lists the formats of input files.
+var Formats = []string{FormatTSV, FormatCSV, FormatNDJSON, FormatCombined}
+
+// ErrNoQuery is returned by sources for lines that are not searches, such as
+// the requests of an access log without query parameter. They are ignored.
+var ErrNoQuery = errors.New("no query")
+
+// Hit is a search read from an input file.
+type Hit struct {
+	Line  int
+	Query string
+	Time  time.Time
+}
+
+// RecordSource reads the hits of an input file.
+type RecordSource interface {
+	// Read returns the next hit of the file, or io.EOF once it has been fully
+	// read. Malformed lines are returned as a *LineError and lines without
+	// query as ErrNoQuery, after which reading can continue; any other error
+	// comes from the underlying reader or means the file cannot be read.
+	Read() (Hit, error)
+	// Position returns where the source is in the file.
+	Position() Position
+}
+
+// Position is where a source is in a file, for another source to read the
+// rest of the file.
+type Position struct {
+	Line       int      // number of lines read
+	Header     []string // of CSV files whose columns are named, once read
+	TimeFormat string   // of the timestamps, once detected
+}
+
+// SourceOptions configures the parsing of input files.
+type SourceOptions struct {
+	Format string // FormatTSV if empty
+	// TSV configures the parsing of TSV and CSV files, whose delimiter is a
+	// comma by default.
+	TSV TSVOptions
+	// TimeField and QueryField are the columns of the timestamp and the query
+	// in TSV and CSV files, numbered from 1 or named in the header of the
+	// files, 1 and 2 by default, and their keys in NDJSON files, time and
+	// query by default.
+	TimeField  string
+	QueryField string
+	// TimeFormat is the format of the timestamps of TSV, CSV and NDJSON files,
+	// a time layout or one of the TimeFormat constants, TimeFormatAuto if
+	// empty.
+	TimeFormat string
+	// QueryParam is the URL parameter holding the query in access logs, q by
+	// default.
+	QueryParam string
+}
+
+// NewRecordSource returns a source reading the hits of a file from r, which
+// starts at the position given.
+func NewRecordSource(file string, r io.Reader, opts SourceOptions, from Position) (RecordSource, error) {
+	switch opts.Format {
+	case FormatTSV, "", FormatCSV:
+		if err := validColumn(opts.TimeField); err != nil {
+			return nil, fmt.Errorf("time field: %v", err)
+		}
+		if err := validColumn(opts.QueryField); err != nil {
+			return nil, fmt.Errorf("query field: %v", err)
+		}
+		if opts.Format == FormatCSV && opts.TSV.Comma == 0 {
+			opts.TSV.Comma = ','
+		}
+		return newDelimitedSource(file, r, opts, from, "1", "2"), nil
+	case FormatNDJSON:
+		return newNDJSONSource(file, r, opts, from), nil
+	case FormatCombined:
+		return newAccessLogSource(file, r, opts, from), nil
+	default:
+		return nil, fmt.Errorf("unknown input format %s", opts.Format)
+	}
+}
+
+// parseDelimiter parses the delimiter of CSV files, a single character, where
+// \t or tab stands for a tab. It returns 0 for the default delimiter.
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+
+	r, n := utf8.DecodeRuneInString(s)
+	if n != len(s) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q", s)
+	}
+	return r, nil
+}
+
+// validColumn checks a column of TSV and CSV files given by its number or its
+// name, numbers starting at 1.
+func validColumn(column string) error {
+	if n, err := strconv.Atoi(column); err == nil && n < 1 {
+		return fmt.Errorf("invalid column %s, columns being numbered from 1", column)
+	}
+	return nil
+}
+
+// delimitedSource reads TSV and CSV files, whose columns are numbered or named
+// in a header.
+type delimitedSource struct {
+	reader      *TSVReader
+	time, query string // columns
+	header      []string
+	resolved    bool // whether the column indexes are known
+	ti, qi      int
+	times       *timeParser
+}
+
+func newDelimitedSource(file string, r io.Reader, opts SourceOptions, from Position, time, query string) *delimitedSource {
+	ret := &delimitedSource{reader: NewTSVReader(file, r, opts.TSV), time: time, query: query, header: from.Header}
+	ret.reader.LineOffset = from.Line
+	ret.times = newSourceTimeParser(opts, from)
+	if opts.TimeField != "" {
+		ret.time = opts.TimeField
+	}
+	if opts.QueryField != "" {
+		ret.query = opts.QueryField
+	}
+
+	// named columns are found in the header
+	ti, terr := strconv.Atoi(ret.time)
+	qi, qerr := strconv.Atoi(ret.query)
+	ret.resolved = terr == nil && qerr == nil
+	ret.ti, ret.qi = ti-1, qi-1
+	return ret
+}
+
+func (d *delimitedSource) Read() (Hit, error) {
+	if !d.resolved {
+		if err := d.readHeader(); err != nil {
+			return Hit{}, err
+		}
+	}
+
+	rec, err := d.reader.Read()
+	if err != nil {
+		return Hit{}, err
+	}
+	if d.ti >= len(rec.Fields) || d.qi >= len(rec.Fields) {
+		return Hit{}, rec.error(d.reader.File, IngestErrorFields, fmt.Errorf("wrong number of fields %d", len(rec.Fields)))
+	}
+
+	t, err := d.times.parse(rec.Fields[d.ti])
+	if err != nil {
+		return Hit{}, rec.error(d.reader.File, IngestErrorDate, err)
+	}
+	return Hit{Line: rec.Line, Query: rec.Fields[d.qi], Time: t}, nil
+}
+
+// readHeader reads the header of the file if it has not been read yet, and
+// finds the indexes of the columns named in it.
+func (d *delimitedSource) readHeader() error {
+	if d.header == nil {
+		rec, err := d.reader.Read()
+		if err != nil {
+			return err
+		}
+		d.header = append([]string(nil), rec.Fields...)
+	}
+
+	d.ti, d.qi = -1, -1
+	for i, name := range d.header {
+		if name == d.time || strconv.Itoa(i+1) == d.time {
+			d.ti = i
+		}
+		if name == d.query || strconv.Itoa(i+1) == d.query {
+			d.qi = i
+		}
+	}
+	if d.ti < 0 || d.qi < 0 {
+		return fmt.Errorf("%s: no column %s or %s in header %q", d.reader.File, d.time, d.query, d.header)
+	}
+	d.resolved = true
+	return nil
+}
+
+func (d *delimitedSource) Position() Position {
+	return Position{Line: d.reader.LineOffset + d.reader.Lines(), Header: d.header, TimeFormat: d.times.detected()}
+}
+
+// error returns a LineError of kind for the record.
+func (r Record) error(file, kind string, err error) *LineError {
+	return &LineError{File: file, Line: r.Line, Kind: kind, Err: err, Raw: append([]byte(nil), r.Raw...)}
+}
+
+// lineReader reads the lines of a file one by one.
+type lineReader struct {
+	file  string
+	r     *bufio.Reader
+	lines int // line breaks read
+	buf   []byte
+}
+
+func newLineReader(file string, r io.Reader, from Position) *lineReader {
+	return &lineReader{file: file, r: bufio.NewReader(r), lines: from.Line}
+}
+
+// next returns the next line that is not blank, including its line break,
+// and its number. The line is only valid until the next call.
+func (l *lineReader) next() ([]byte, int, error) {
+	for {
+		l.buf = l.buf[:0]
+		for {
+			b, err := l.r.ReadSlice('\n')
+			l.buf = append(l.buf, b...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil && (err != io.EOF || len(l.buf) == 0) {
+				return nil, 0, err
+			}
+			break
+		}
+
+		line := l.lines + 1
+		if l.buf[len(l.buf)-1] == '\n' {
+			l.lines++
+		}
+		if len(bytes.TrimSpace(l.buf)) > 0 {
+			return l.buf, line, nil
+		}
+	}
+}
+
+// error returns a LineError of kind for a line.
+func (l *lineReader) error(raw []byte, line int, kind string, err error) *LineError {
+	return &LineError{File: l.file, Line: line, Kind: kind, Err: err, Raw: append([]byte(nil), raw...)}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordSources(t *testing.T) {
+	at := func(s string) time.Time {
+		ti, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ti
+	}
+
+	type result struct {
+		line  int
+		query string
+		time  time.Time
+		err   string // kind of LineError, or error message
+	}
+	tests := []struct {
+		name  string
+		opts  SourceOptions
+		input string
+		want  []result
+	}{
+		{
+			name:  "tsv",
+			opts:  SourceOptions{TSV: TSVOptions{FieldsPerRecord: -1}},
+			input: "2015-08-01 00:03:43\tgo\textra\n\n2015-08-01 00:03:44\n",
+			want: []result{
+				{line: 1, query: "go", time: at("2015-08-01T00:03:43Z")},
+				{line: 3, err: IngestErrorFields},
+			},
+		},
+		{
+			name:  "csv with named columns",
+			opts:  SourceOptions{Format: FormatCSV, TSV: TSVOptions{Comma: ';'}, TimeField: "ts", QueryField: "q"},
+			input: "id;q;ts\n1;\"a;b\";2015-08-01 00:03:43\n2;c;yesterday\n",
+			want: []result{
+				{line: 2, query: "a;b", time: at("2015-08-01T00:03:43Z")},
+				{line: 3, err: IngestErrorDate},
+			},
+		},
+		{
+			name:  "csv with numbered columns",
+			opts:  SourceOptions{Format: FormatCSV, TimeField: "3", QueryField: "1"},
+			input: "go,x,2015-08-01 00:03:43\n",
+			want:  []result{{line: 1, query: "go", time: at("2015-08-01T00:03:43Z")}},
+		},
+		{
+			name:  "csv without column",
+			opts:  SourceOptions{Format: FormatCSV, QueryField: "query"},
+			input: "time,search\n",
+			want:  []result{{err: `no column 1 or query in header ["time" "search"]`}},
+		},
+		{
+			name: "ndjson",
+			opts: SourceOptions{Format: FormatNDJSON, QueryField: "search"},
+			input: `{"time":"2015-08-01 00:03:43","search":"go","user":1}` + "\n" +
+				`{"time":"2015-08-01 00:03:44","search":3}` + "\n" +
+				`{"time":"2015-08-01",` + "\n" +
+				"   \n" +
+				`{"time":"2015-08-01","search":"go"}`,
+			want: []result{
+				{line: 1, query: "go", time: at("2015-08-01T00:03:43Z")},
+				{line: 2, err: IngestErrorFields},
+				{line: 3, err: IngestErrorSyntax},
+				{line: 5, err: IngestErrorDate},
+			},
+		},
+		{
+			name:  "csv with epoch timestamps",
+			opts:  SourceOptions{Format: FormatCSV},
+			input: "1438387423,go\n2015-08-01 00:03:44,go\n1438387425,go\n",
+			want: []result{
+				{line: 1, query: "go", time: at("2015-08-01T00:03:43Z")},
+				{line: 2, err: IngestErrorDate},
+				{line: 3, query: "go", time: at("2015-08-01T00:03:45Z")},
+			},
+		},
+		{
+			name:  "ndjson with epoch timestamps",
+			opts:  SourceOptions{Format: FormatNDJSON, TimeFormat: TimeFormatUnixMilli},
+			input: `{"time":1438387423000,"query":"go"}` + "\n" + `{"time":"1438387424000","query":"go"} {}` + "\n" + `{"time":true,"query":"go"}` + "\n",
+			want: []result{
+				{line: 1, query: "go", time: at("2015-08-01T00:03:43Z")},
+				{line: 2, err: IngestErrorSyntax},
+				{line: 3, err: IngestErrorFields},
+			},
+		},
+		{
+			name:  "tsv with offsets",
+			opts:  SourceOptions{TimeFormat: TimeFormatRFC3339},
+			input: "2015-08-01T02:03:43+02:00\tgo\n",
+			want:  []result{{line: 1, query: "go", time: at("2015-08-01T00:03:43Z")}},
+		},
+		{
+			name: "combined",
+			opts: SourceOptions{Format: FormatCombined},
+			input: `127.0.0.1 - frank [10/Oct/2015:13:55:36 -0700] "GET /search?q=hello+world&page=2 HTTP/1.1" 200 2326 "-" "curl/8.0"` + "\n" +
+				`127.0.0.1 - - [10/Oct/2015:13:55:37 -0700] "GET /static/app.js HTTP/1.1" 200 512` + "\n" +
+				`127.0.0.1 - - [10/Oct/2015:13:55:38 -0700] "-" 400 0 "-" "-"` + "\n" +
+				`127.0.0.1 - - [10/Oct/2015:13:55:39 +0000] "GET /?q=%22quoted%22 HTTP/1.1" 200 12 "-" "Mozilla \"5.0\""` + "\n" +
+				`not an access log` + "\n" +
+				`127.0.0.1 - - [31/Sep/2015:13:55:39 +0000] "GET /?q=x HTTP/1.1" 200 12` + "\n",
+			want: []result{
+				{line: 1, query: "hello world", time: at("2015-10-10T20:55:36Z")},
+				{line: 2, err: ErrNoQuery.Error()},
+				{line: 3, err: ErrNoQuery.Error()},
+				{line: 4, query: `"quoted"`, time: at("2015-10-10T13:55:39Z")},
+				{line: 5, err: IngestErrorSyntax},
+				{line: 6, err: IngestErrorDate},
+			},
+		},
+		{
+			name:  "combined with another parameter",
+			opts:  SourceOptions{Format: FormatCombined, QueryParam: "query"},
+			input: `::1 - - [10/Oct/2015:13:55:36 +0000] "GET /search?q=no&query=yes HTTP/2.0" 200 1 "-" "-"` + "\n",
+			want:  []result{{line: 1, query: "yes", time: at("2015-10-10T13:55:36Z")}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := NewRecordSource("logs", strings.NewReader(tc.input), tc.opts, Position{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []result
+			for len(got) <= len(tc.want) {
+				hit, err := src.Read()
+				if err == io.EOF {
+					break
+				}
+				if lerr, ok := err.(*LineError); ok {
+					got = append(got, result{line: lerr.Line, err: lerr.Kind})
+					continue
+				}
+				if err == ErrNoQuery {
+					got = append(got, result{line: src.Position().Line, err: err.Error()})
+					continue
+				}
+				if err != nil {
+					got = append(got, result{err: strings.TrimPrefix(err.Error(), "logs: ")})
+					break
+				}
+				got = append(got, result{line: hit.Line, query: hit.Query, time: hit.Time})
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wanted %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRecordSourceColumns(t *testing.T) {
+	tests := []struct {
+		opts SourceOptions
+		err  string
+	}{
+		{SourceOptions{TimeField: "0"}, "time field: invalid column 0, columns being numbered from 1"},
+		{SourceOptions{Format: FormatCSV, QueryField: "-1"}, "query field: invalid column -1, columns being numbered from 1"},
+		{SourceOptions{Format: FormatCSV, TimeField: "date", QueryField: "3"}, ""},
+		{SourceOptions{Format: FormatNDJSON, TimeField: "0"}, ""},
+	}
+	for _, tc := range tests {
+		_, err := NewRecordSource("logs", strings.NewReader(""), tc.opts, Position{})
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("%+v: wanted error %q, got %v", tc.opts, tc.err, err)
+		}
+	}
+}
+
+func TestRecordSourcePosition(t *testing.T) {
+	opts := SourceOptions{Format: FormatCSV, TimeField: "time", QueryField: "query"}
+	src, err := NewRecordSource("logs.csv", strings.NewReader("query,time\ngo,2015-08-01 00:03:43\n"), opts, Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := src.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the rest of the file is read with the header and line numbers of its start
+	pos := src.Position()
+	if want := (Position{Line: 2, Header: []string{"query", "time"}, TimeFormat: Second}); !reflect.DeepEqual(pos, want) {
+		t.Fatalf("wanted position %+v, got %+v", want, pos)
+	}
+	src, err = NewRecordSource("logs.csv", strings.NewReader("rust,2015-08-01 00:03:44\n"), opts, pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hit, err := src.Read()
+	if err != nil || hit.Line != 3 || hit.Query != "rust" {
+		t.Errorf("wanted rust on line 3, got %+v, %v", hit, err)
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want rune
+		err  bool
+	}{
+		{"", 0, false},
+		{";", ';', false},
+		{`\t`, '\t', false},
+		{"tab", '\t', false},
+		{"|", '|', false},
+		{"§", '§', false},
+		{"ab", 0, true},
+		{`"`, 0, true},
+		{"\n", 0, true},
+	}
+	for _, tc := range tests {
+		got, err := parseDelimiter(tc.in)
+		if got != tc.want || (err != nil) != tc.err {
+			t.Errorf("%q: wanted %q (error %v), got %q, %v", tc.in, tc.want, tc.err, got, err)
+		}
+	}
+}
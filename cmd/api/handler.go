@@ -2,21 +2,38 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 	"tpaulmyer/algolia/datetree"
 	"tpaulmyer/algolia/null"
 )
 
 // Handler is the structure that handles calls to the API.
 type Handler struct {
-	Logger   *log.Logger
-	DateTree *datetree.Tree
+	Logger  *slog.Logger
+	Index   *Index            // default index, served by the routes not naming one
+	Indexes map[string]*Index // by name, including the default index
+	Keys    *KeyStore         // nil if authentication is disabled
+
+	// CacheMaxAge is the time clients and proxies can cache responses.
+	CacheMaxAge time.Duration
+	Cache       *ResponseCache // nil if responses are not cached
+
+	// RelativeTo is the reference time of relative dates, RelativeToClock if
+	// empty.
+	RelativeTo string
+
+	Clock Clock         // nil for the system clock
+	Stop  chan struct{} // closed to end the streams on shutdown
 }
 
-// APIError is used to return a JSON error to the user.
-type APIError struct {
-	Error string `json:"error"`
+// now returns the current time of the handler clock.
+func (h *Handler) now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+	return h.Clock.Now()
 }
 
 // CountResult is used to return the result of a count query to the API user.
@@ -27,18 +44,43 @@ type CountResult struct {
 // Count is the handler responsible for the /1/queries/count/<DATE_PREFIX> route.
 func (h *Handler) Count(w http.ResponseWriter, r *http.Request) {
 	t := GetDateInContext(r)
-	s := datetree.Search{
-		Year:   t.Year(),
-		Month:  null.Int{Valid: len(t.Layout) >= len(Month), Int: int(t.Month())},
-		Day:    null.Int{Valid: len(t.Layout) >= len(Day), Int: t.Day()},
-		Hour:   null.Int{Valid: len(t.Layout) >= len(Hour), Int: t.Hour()},
-		Minute: null.Int{Valid: len(t.Layout) >= len(Minute), Int: t.Minute()},
-		Second: null.Int{Valid: len(t.Layout) >= len(Second), Int: t.Second()},
+	s, ok, err := search(r, t)
+	if err != nil {
+		h.Error(w, r, err)
+		return
 	}
 
-	count := h.DateTree.Count(s)
+	tree := h.index(r).Tree()
+	var count int
+	if ok {
+		count = tree.Count(s)
+	} else {
+		count = tree.CountRange(t.Time, t.To)
+	}
 	out := CountResult{Count: count}
-	h.Respond(w, out, http.StatusOK)
+	h.Respond(w, r, out, http.StatusOK)
+}
+
+// search returns the search of the date tree matching the period of a request,
+// and whether there is one, the period being searched as a range otherwise.
+// The weekday and hour parameters restrict the search of a year.
+func search(r *http.Request, t DateInfo) (datetree.Search, bool, error) {
+	s, ok := t.Search()
+	weekday, hour, err := GetCycleParameters(r)
+	if err != nil || (!weekday.Valid && !hour.Valid) {
+		return s, ok, err
+	}
+
+	if !ok || s.Month.Valid || s.Week.Valid {
+		param := "weekday"
+		if hour.Valid {
+			param = "hour"
+		}
+		return s, ok, &Error{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Param: param, Message: param + " parameter can only be used with a year"}
+	}
+
+	s.Weekday, s.HourOfDay = weekday, hour
+	return s, true, nil
 }
 
 // Query is the API representation of a query.
@@ -57,21 +99,25 @@ func (h *Handler) Popular(w http.ResponseWriter, r *http.Request) {
 	t := GetDateInContext(r)
 	size, err := GetSizeParameter(r)
 	if err != nil {
-		out := APIError{Error: err.Error()}
-		h.Respond(w, out, http.StatusBadRequest)
+		h.Error(w, r, err)
 		return
 	}
+	GetRequestInfo(r).Size = null.Int{Valid: true, Int: size}
 
-	s := datetree.Search{
-		Year:       t.Year(),
-		Month:      null.Int{Valid: len(t.Layout) >= len(Month), Int: int(t.Month())},
-		Day:        null.Int{Valid: len(t.Layout) >= len(Day), Int: t.Day()},
-		Hour:       null.Int{Valid: len(t.Layout) >= len(Hour), Int: t.Hour()},
-		Minute:     null.Int{Valid: len(t.Layout) >= len(Minute), Int: t.Minute()},
-		Second:     null.Int{Valid: len(t.Layout) >= len(Second), Int: t.Second()},
-		Popularity: size,
+	s, ok, err := search(r, t)
+	if err != nil {
+		h.Error(w, r, err)
+		return
+	}
+
+	tree := h.index(r).Tree()
+	var pop []datetree.Popularity
+	if ok {
+		s.Popularity = size
+		pop = tree.Popular(s)
+	} else {
+		pop = tree.PopularRange(t.Time, t.To, size)
 	}
-	pop := h.DateTree.Popular(s)
 
 	out := PopularResult{
 		Queries: make([]Query, len(pop)),
@@ -80,25 +126,22 @@ func (h *Handler) Popular(w http.ResponseWriter, r *http.Request) {
 		out.Queries[i] = Query(v)
 	}
 
-	h.Respond(w, out, http.StatusOK)
+	h.Respond(w, r, out, http.StatusOK)
 }
 
 // Respond returns a payload and a statuscode to the user.
-func (h *Handler) Respond(w http.ResponseWriter, out interface{}, statusCode int) {
+func (h *Handler) Respond(w http.ResponseWriter, r *http.Request, out interface{}, statusCode int) {
 	d, err := json.Marshal(out)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"an internal error occurred"}`))
-		h.Logger.Println("got internal error while marshalling response:", err.Error())
+		_, _ = w.Write([]byte(`{"error":"an internal error occurred","code":"internal_error"}`))
+		h.RequestLogger(r).Error("failed to marshal response", "err", err)
 		return
 	}
 
 	w.WriteHeader(statusCode)
 	_, err = w.Write(d)
 	if err != nil {
-		h.Logger.Println("failed to return response to user:", err)
-		return
+		h.RequestLogger(r).Warn("failed to return response to user", "err", err)
 	}
-
-	h.Logger.Println("response sent with status", statusCode)
 }
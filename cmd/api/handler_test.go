@@ -2,13 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
-	"time"
 	"tpaulmyer/algolia/datetree"
 )
 
@@ -31,15 +31,15 @@ var sampleData = `
 func TestHandlerCount(t *testing.T) {
 	// create silent handler
 	h := Handler{
-		Logger:   log.New(ioutil.Discard, "", 0),
-		DateTree: getTreeForTests(t),
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
 	}
 
 	t.Run("no result", func(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015-08-02%2010:05:07", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -55,7 +55,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -71,7 +71,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015-08", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -87,7 +87,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015-08-22", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -103,7 +103,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015-09-03%2000", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -119,7 +119,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015-09-03%2000:05", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -135,7 +135,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015-08-03%2000:00:07", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -151,13 +151,13 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
-		if w.Code != http.StatusBadRequest {
-			t.Error("code should be bad request")
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Error("code should be not found")
 			return
 		}
 		body := w.Body.String()
-		want := `{"error":"no date specified in url"}`
+		want := `{"error":"no route for /v1/queries/count/","code":"not_found","doc":"routes are /1/indexes/{index}/queries/count/{date} and /1/indexes/{index}/queries/popular/{date}?size={size}"}`
 		if body != want {
 			t.Errorf("wanted %s, got %s", want, body)
 		}
@@ -167,7 +167,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/zorglub", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusBadRequest {
 			t.Error("code should be bad request")
 			return
@@ -181,7 +181,7 @@ func TestHandlerCount(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/count/2015-08-02%2015:04:05-999999999", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Count)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Count))).ServeHTTP(w, r)
 		if w.Code != http.StatusBadRequest {
 			t.Error("code should be bad request")
 			return
@@ -195,8 +195,8 @@ func TestHandlerCount(t *testing.T) {
 func TestHandlerPopularity(t *testing.T) {
 	// create silent handler
 	h := Handler{
-		Logger:   log.New(ioutil.Discard, "", 0),
-		DateTree: getTreeForTests(t),
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
 	}
 
 	getBody := func(t *testing.T, w *httptest.ResponseRecorder) PopularResult {
@@ -213,7 +213,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015-08-02%2010:05:07?size=8", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -228,13 +228,13 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusBadRequest {
 			t.Error("code should be ok")
 			return
 		}
 		body := w.Body.String()
-		want := `{"error":"missing size parameter"}`
+		want := `{"error":"missing size parameter","code":"missing_parameter","param":"size","doc":"check the required parameters of the route"}`
 		if body != want {
 			t.Errorf("wanted %s, got %s", want, body)
 		}
@@ -244,7 +244,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015?size=10", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -273,7 +273,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015?size=3", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -298,7 +298,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015?size=-1", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusBadRequest {
 			t.Error("code should be bad request")
 			return
@@ -313,7 +313,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015?size=lol", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusBadRequest {
 			t.Error("code should be bad request")
 			return
@@ -328,7 +328,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015-08?size=10", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -355,7 +355,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015-08-22?size=5", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -379,7 +379,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015-09-03%2000?size=5", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -404,7 +404,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015-09-03%2000:05?size=10", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -428,7 +428,7 @@ func TestHandlerPopularity(t *testing.T) {
 		r := httptest.NewRequest("GET", "/v1/queries/popularity/2015-08-03%2000:00:07?size=10", nil)
 		w := httptest.NewRecorder()
 
-		h.DateMiddleware(http.HandlerFunc(h.Popular)).ServeHTTP(w, r)
+		queryRouteForTests(&h, h.DateMiddleware(http.HandlerFunc(h.Popular))).ServeHTTP(w, r)
 		if w.Code != http.StatusOK {
 			t.Error("code should be ok")
 			return
@@ -449,29 +449,29 @@ func TestHandlerPopularity(t *testing.T) {
 	})
 }
 
+func getIndexForTests(t *testing.T) *Index {
+	i := new(Index)
+	i.SetTree(getTreeForTests(t))
+	return i
+}
+
 func getTreeForTests(t *testing.T) *datetree.Tree {
-	r := strings.NewReader(sampleData)
-	tsvr := NewTSVReader(r)
-	go func() {
-		for err := range tsvr.Error() {
-			t.Error(err)
-		}
-	}()
+	r, err := NewRecordSource("sample", strings.NewReader(sampleData), SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}, Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// create new date tree and insert every line in it
 	tree := datetree.NewTree()
-	for l := range tsvr.Lines() {
-		if len(l) != 2 {
-			continue
+	for {
+		hit, err := r.Read()
+		if err == io.EOF {
+			break
 		}
-
-		ti, err := time.Parse(Second, l[0])
 		if err != nil {
-			t.Error(err)
-			continue
+			t.Fatal(err)
 		}
-
-		tree.Insert(l[1], ti)
+		tree.Insert(hit.Query, hit.Time)
 	}
 
 	tree.IndexPopularity()
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// retryAfterLoading is the number of seconds clients are asked to wait before
+// retrying a request made while the index is loading.
+const retryAfterLoading = 5
+
+// HealthResult is returned to the user by the /healthz and /readyz routes.
+type HealthResult struct {
+	Status   string   `json:"status"`
+	Progress *float64 `json:"progress,omitempty"`
+}
+
+// Healthz is the handler responsible for the /healthz route. It reports the
+// process as alive as soon as it is able to serve HTTP requests.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	h.Respond(w, r, HealthResult{Status: "ok"}, http.StatusOK)
+}
+
+// Readyz is the handler responsible for the /readyz route. It reports the API
+// as ready once every index has been loaded, and gives the mean loading
+// progress of the others as a percentage otherwise.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	var progress float64
+	var loading int
+	for _, i := range h.indexes() {
+		if !i.Ready() {
+			progress += i.Progress()
+			loading++
+		}
+	}
+	if loading == 0 {
+		h.Respond(w, r, HealthResult{Status: "ready"}, http.StatusOK)
+		return
+	}
+
+	progress /= float64(loading)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterLoading))
+	h.Respond(w, r, HealthResult{Status: "loading", Progress: &progress}, http.StatusServiceUnavailable)
+}
+
+// ReadyMiddleware is a middleware that rejects requests with a 503 status until
+// the index is loaded.
+func (h *Handler) ReadyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.index(r).Ready() {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterLoading))
+			h.Error(w, r, &Error{Status: http.StatusServiceUnavailable, Code: CodeIndexLoading, Message: "the index is still loading"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
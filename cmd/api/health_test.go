@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  new(Index),
+	}
+	count := queryRouteForTests(&h, h.ReadyMiddleware(h.DateMiddleware(http.HandlerFunc(h.Count))))
+
+	t.Run("liveness", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.Healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("wanted status %d, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("loading", func(t *testing.T) {
+		h.Index.size, h.Index.read = 200, 50
+		w := httptest.NewRecorder()
+		h.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("wanted status %d, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+		var res HealthResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != "loading" || res.Progress == nil || *res.Progress != 25 {
+			t.Errorf("wrong readiness payload %s", w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		count.ServeHTTP(w, httptest.NewRequest("GET", "/1/queries/count/2015", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("wanted status %d, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("Retry-After header should be set")
+		}
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		h.Index.SetTree(getTreeForTests(t))
+		w := httptest.NewRecorder()
+		h.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("wanted status %d, got %d", http.StatusOK, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		count.ServeHTTP(w, httptest.NewRequest("GET", "/1/queries/count/2015", nil))
+		if body := w.Body.String(); body != `{"count":7}` {
+			t.Errorf("wanted %s, got %s", `{"count":7}`, body)
+		}
+	})
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tpaulmyer/algolia/datetree"
+)
+
+// Index is a dataset read from TSV files and served by the API. Its tree can
+// be rebuilt from the files in the background and swapped atomically while
+// requests are being served.
+type Index struct {
+	Name    string
+	Files   []string // files, directories or globs, see expandSources
+	Options IngestOptions
+	Logger  *slog.Logger
+	Metrics *Metrics
+
+	tree   atomic.Value // *datetree.Tree
+	report atomic.Value // *IngestReport
+
+	// progress of the current load, in bytes
+	read int64
+	size int64
+
+	mu      sync.Mutex
+	loading bool
+
+	stdinRead bool // only accessed by loads
+
+	// followers of the files of the last load, in follow mode
+	stopFollowing chan struct{}
+	followers     sync.WaitGroup
+}
+
+// ErrLoading is returned when a load is requested while another is running.
+var ErrLoading = errors.New("the index is already being loaded")
+
+// Tree returns the tree currently served, or nil if none has been loaded yet.
+func (i *Index) Tree() *datetree.Tree {
+	t, _ := i.tree.Load().(*datetree.Tree)
+	return t
+}
+
+// Ready reports whether the index has a tree to serve.
+func (i *Index) Ready() bool {
+	return i.Tree() != nil
+}
+
+// Loading reports whether the index is being loaded.
+func (i *Index) Loading() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.loading
+}
+
+// Progress returns the percentage of the TSV files read by the current load, or
+// by the last one if the index is not being loaded.
+func (i *Index) Progress() float64 {
+	size := atomic.LoadInt64(&i.size)
+	if size <= 0 {
+		return 0
+	}
+
+	return 100 * float64(atomic.LoadInt64(&i.read)) / float64(size)
+}
+
+// Report returns the report of the last completed load, or nil if none has
+// completed yet.
+func (i *Index) Report() *IngestReport {
+	r, _ := i.report.Load().(*IngestReport)
+	return r
+}
+
+// addReport adds the counts of the lines read by a follower to the report of
+// the load being followed, unless stop shows that it is not followed anymore.
+// The report is replaced rather than modified, as it can be being read.
+func (i *Index) addReport(o *IngestReport, stop <-chan struct{}) {
+	if o.Lines == 0 && len(o.RejectsFiles) == 0 {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	cur := i.Report()
+	if cur == nil || i.stopFollowing != stop {
+		return
+	}
+	r := *cur
+	r.Rejected = make(map[string]int, len(cur.Rejected))
+	for k, n := range cur.Rejected {
+		r.Rejected[k] = n
+	}
+	r.Filtered = nil
+	for k, n := range cur.Filtered {
+		r.filtered(k, n)
+	}
+	r.RejectsFiles = append([]string(nil), cur.RejectsFiles...)
+	r.add(o)
+	i.report.Store(&r)
+}
+
+// SetTree atomically replaces the tree served by the index.
+func (i *Index) SetTree(t *datetree.Tree) {
+	i.tree.Store(t)
+}
+
+// Load reads the TSV files into a new tree, indexes its popularity and swaps it
+// with the one currently served. The current tree is kept if loading fails. It
+// returns ErrLoading if the index is already being loaded.
+func (i *Index) Load() error {
+	if !i.startLoading() {
+		return ErrLoading
+	}
+	defer i.stopLoading()
+
+	return i.load()
+}
+
+// Reload starts loading the index in the background. It returns ErrLoading if
+// the index is already being loaded.
+func (i *Index) Reload() error {
+	if !i.startLoading() {
+		return ErrLoading
+	}
+
+	go func() {
+		defer i.stopLoading()
+		if err := i.load(); err != nil {
+			i.Logger.Error("failed to reload index", "index", i.Name, "files", i.Files, "err", err)
+		}
+	}()
+
+	return nil
+}
+
+func (i *Index) load() error {
+	report := &IngestReport{Started: time.Now(), Rejected: map[string]int{}}
+	t, follows, err := i.build(report)
+	report.Finished = time.Now()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	i.report.Store(report)
+	i.Logger.Info("ingestion report", "index", i.Name, "report", report)
+	if err != nil {
+		return err
+	}
+
+	i.Logger.Info("index loaded", "index", i.Name, "files", len(report.Files), "queries", t.TotalCount, "duration", report.Finished.Sub(report.Started))
+	i.SetTree(t)
+	i.StopFollowing()
+	if i.Options.Follow {
+		i.startFollowing(t, follows)
+	}
+	return nil
+}
+
+func (i *Index) startLoading() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.loading {
+		return false
+	}
+
+	i.loading = true
+	return true
+}
+
+func (i *Index) stopLoading() {
+	i.mu.Lock()
+	i.loading = false
+	i.mu.Unlock()
+}
+
+// build reads the TSV files in parallel and returns the corresponding indexed
+// tree, with where the files that can be followed were read up to.
+func (i *Index) build(report *IngestReport) (*datetree.Tree, []*followState, error) {
+	files, err := expandSources(i.Files)
+	if err != nil {
+		return nil, nil, err
+	}
+	report.Files = files
+
+	var size int64
+	for _, file := range files {
+		if file == Stdin && i.stdinRead {
+			return nil, nil, ErrStdinConsumed
+		}
+		if fi, err := os.Stat(file); err == nil && file != Stdin {
+			size += fi.Size()
+		}
+	}
+
+	m := i.Metrics
+	m.IngestDone.With(i.Name).Set(0)
+	m.IngestRead.With(i.Name).Set(0)
+	m.IngestSize.With(i.Name).Set(float64(size))
+	atomic.StoreInt64(&i.read, 0)
+	atomic.StoreInt64(&i.size, size)
+
+	// Create new date tree and insert the lines of every file in it.
+	tree := datetree.NewTree()
+	reports := make([]*IngestReport, len(files))
+	var follows []*followState
+	var mu sync.Mutex
+	errs := make([]error, len(files))
+	var aborted atomic.Bool
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, runtime.GOMAXPROCS(0))
+	// the bursts of the files read at the same time are counted together: the
+	// counters of the files read first are created before any is read, the
+	// other ones once their file has a worker, so that the files waiting for
+	// one do not hold the counts back
+	bursts := newBursts()
+	counters := make([]*burstCounter, len(files))
+	for n := 0; n < len(files) && n < cap(workers); n++ {
+		counters[n] = bursts.counter()
+	}
+	for n, file := range files {
+		reports[n] = &IngestReport{Rejected: map[string]int{}}
+		wg.Add(1)
+		workers <- struct{}{}
+		c := counters[n]
+		if c == nil {
+			c = bursts.counter()
+		}
+		go func(n int, file string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			defer c.close()
+			f, err := i.ingestFile(tree, c, file, reports[n], &aborted)
+			if errs[n] = err; err != nil {
+				aborted.Store(true)
+			}
+			if f != nil {
+				mu.Lock()
+				follows = append(follows, f)
+				mu.Unlock()
+			}
+		}(n, file)
+	}
+	wg.Wait()
+
+	for n := range files {
+		report.add(reports[n])
+	}
+	for _, err := range errs {
+		if err != nil && err != errIngestAborted {
+			return nil, nil, err
+		}
+	}
+
+	i.Logger.Info("indexing popularity", "index", i.Name)
+	tree.IndexPopularity()
+	m.SetTreeStats(i.Name, tree.Stats())
+	m.IngestDone.With(i.Name).Set(1)
+	return tree, follows, nil
+}
+
+// ingestFile inserts the lines of a file into tree, decompressing it if needed,
+// its hits being counted with c to filter bursts. In follow mode, it returns
+// where an uncompressed file was read up to.
+func (i *Index) ingestFile(tree *datetree.Tree, c *burstCounter, file string, report *IngestReport, aborted *atomic.Bool) (*followState, error) {
+	name := sourceName(file)
+	var r io.Reader
+	var f *os.File
+	if file == Stdin {
+		i.stdinRead = true
+		r = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// the rejects of the previous load are replaced
+	if i.Options.ErrorPolicy == PolicyQuarantine {
+		if err := os.Remove(name + RejectsSuffix); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	i.Logger.Info("reading file", "index", i.Name, "file", file)
+	d, compressed, err := decompress(file, &progressReader{r: r, index: i})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	src, err := NewRecordSource(name, d, i.Options.Source, Position{})
+	if err != nil {
+		return nil, err
+	}
+	err = i.ingest(tree, c, name, src, report, aborted)
+	var lerr *LineError
+	if err != nil && err != errIngestAborted && !errors.As(err, &lerr) {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if err != nil || !i.Options.Follow || f == nil || compressed {
+		return nil, err
+	}
+
+	fs := &followState{file: file, position: src.Position(), bursts: c.b}
+	if fs.info, err = f.Stat(); err != nil {
+		return nil, err
+	}
+	if fs.offset, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// progressReader wraps the TSV files in order to report loading progress.
+type progressReader struct {
+	r     io.Reader
+	index *Index
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	atomic.AddInt64(&p.index.read, int64(n))
+	p.index.Metrics.IngestRead.With(p.index.Name).Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"tpaulmyer/algolia/datetree"
+	"tpaulmyer/algolia/null"
+)
+
+func TestIndexReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logs.tsv")
+	if err := ioutil.WriteFile(file, []byte(sampleData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	h := Handler{
+		Logger: logger,
+		Index:  &Index{Files: []string{file}, Logger: logger, Metrics: NewMetrics()},
+	}
+	if err := h.Index.Load(); err != nil {
+		t.Fatal(err)
+	}
+	old := h.Index.Tree()
+	if old.TotalCount != 13 {
+		t.Fatalf("wanted 13 hits, got %d", old.TotalCount)
+	}
+
+	t.Run("wrong method", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.Reload(w, httptest.NewRequest("GET", "/1/admin/reload", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("wanted status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+
+	t.Run("failed reload keeps tree", func(t *testing.T) {
+		i := &Index{Files: []string{filepath.Join(t.TempDir(), "missing.tsv")}, Logger: logger, Metrics: NewMetrics()}
+		i.SetTree(old)
+		if err := i.Load(); err == nil {
+			t.Error("loading a missing file should fail")
+		}
+		if i.Tree() != old {
+			t.Error("tree should not have been replaced")
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString("2015-09-10 11:05:11\tnew\n")
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		h.Reload(w, httptest.NewRequest("POST", "/1/admin/reload", nil))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("wanted status %d, got %d", http.StatusAccepted, w.Code)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for h.Index.Tree() == old && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		tree := h.Index.Tree()
+		if tree == old {
+			t.Fatal("tree has not been swapped")
+		}
+		if n := tree.Count(datetree.Search{Year: 2015}); n != 8 {
+			t.Errorf("wanted 8 distinct queries after reload, got %d", n)
+		}
+	})
+	t.Run("already loading", func(t *testing.T) {
+		h := Handler{Logger: logger, Indexes: map[string]*Index{
+			"a": {Name: "a", Logger: logger},
+			"b": {Name: "b", Logger: logger},
+		}}
+		for _, i := range h.Indexes {
+			i.startLoading()
+		}
+
+		var out APIError
+		w := httptest.NewRecorder()
+		h.Reload(w, httptest.NewRequest("POST", "/1/admin/reload", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusConflict || out.Code != CodeReloadInProgress {
+			t.Fatalf("wanted a %s error, got %d %+v", CodeReloadInProgress, w.Code, out)
+		}
+		if want := "indexes a, b are already being loaded"; out.Error != want {
+			t.Errorf("wanted message %q, got %q", want, out.Error)
+		}
+	})
+}
+
+func TestIngestPolicies(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "logs.tsv")
+	bad := "2015-13-45 00:00:00\tbad date\n" +
+		"one field\n" +
+		"2015-08-03 00:00:07\tthree\tfields\n" +
+		"2015-08-03 00:00:07\tbare\"quote\n"
+	if err := ioutil.WriteFile(file, []byte(sampleData+bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     IngestOptions
+		err      string
+		inserted int
+		rejected map[string]int
+		rejects  string
+	}{
+		{"skip", IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}}, "", 13,
+			map[string]int{IngestErrorDate: 1, IngestErrorFields: 2, IngestErrorSyntax: 1}, ""},
+		{"fail", IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}, ErrorPolicy: PolicyFail}, "logs.tsv:15: parsing time", 13,
+			map[string]int{IngestErrorDate: 1}, ""},
+		{"quarantine", IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}, ErrorPolicy: PolicyQuarantine}, "", 13,
+			map[string]int{IngestErrorDate: 1, IngestErrorFields: 2, IngestErrorSyntax: 1}, bad},
+		{"lenient", IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: -1, LazyQuotes: true}}, ErrorPolicy: PolicyQuarantine}, "", 15,
+			map[string]int{IngestErrorDate: 1, IngestErrorFields: 1}, "2015-13-45 00:00:00\tbad date\none field\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			i := &Index{Name: DefaultIndex, Files: []string{file}, Options: tc.opts, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)), Metrics: NewMetrics()}
+			err := i.Load()
+			if tc.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("wanted error %q, got %v", tc.err, err)
+			}
+
+			report := i.Report()
+			if report == nil {
+				t.Fatal("load should be reported")
+			}
+			if report.Inserted != tc.inserted || !reflect.DeepEqual(report.Rejected, tc.rejected) {
+				t.Errorf("wanted %d inserted and %v rejected lines, got %+v", tc.inserted, tc.rejected, report)
+			}
+			if tc.err != "" {
+				if report.Error == "" || i.Tree() != nil {
+					t.Errorf("failed load should be reported without serving a tree, got %+v", report)
+				}
+				return
+			}
+			if report.Lines != 17 || i.Tree().TotalCount != tc.inserted {
+				t.Errorf("wanted 17 lines read and %d hits, got %+v", tc.inserted, report)
+			}
+
+			rejects, err := ioutil.ReadFile(file + RejectsSuffix)
+			if tc.rejects == "" {
+				if !os.IsNotExist(err) || len(report.RejectsFiles) != 0 {
+					t.Errorf("no rejects file should be written, got %v", err)
+				}
+				return
+			}
+			if string(rejects) != tc.rejects || !reflect.DeepEqual(report.RejectsFiles, []string{file + RejectsSuffix}) {
+				t.Errorf("wanted rejects %q, got %q", tc.rejects, rejects)
+			}
+		})
+	}
+}
+
+func TestIngestAccessLogs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	logs := `10.0.0.1 - - [03/Aug/2015:10:00:00 +0000] "GET /search?q=go HTTP/1.1" 200 10 "-" "-"
+10.0.0.2 - - [03/Aug/2015:10:00:01 +0000] "GET /favicon.ico HTTP/1.1" 404 0 "-" "-"
+10.0.0.3 - - [03/Aug/2015:10:00:02 +0200] "GET /search?q=go&page=2 HTTP/1.1" 200 10 "-" "-"
+`
+	if err := ioutil.WriteFile(file, []byte(logs), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := IngestOptions{Source: SourceOptions{Format: FormatCombined}}
+	i := &Index{Name: DefaultIndex, Files: []string{file}, Options: opts, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)), Metrics: NewMetrics()}
+	if err := i.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if r := i.Report(); r.Lines != 3 || r.Inserted != 2 || r.Ignored != 1 {
+		t.Errorf("wanted 2 hits inserted and 1 line ignored, got %+v", r)
+	}
+	s := datetree.Search{Year: 2015, Month: null.Int{Valid: true, Int: 8}, Day: null.Int{Valid: true, Int: 3}, Hour: null.Int{Valid: true, Int: 8}, Popularity: 1}
+	if got := i.Tree().Popular(s); !reflect.DeepEqual(got, []datetree.Popularity{{Query: "go", Count: 1}}) {
+		t.Errorf("the time of the hits should be in UTC, got %v at 8am", got)
+	}
+}
+
+func TestIngestNormalized(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logs.tsv")
+	logs := "2015-08-03 10:00:00\tElixir\n" +
+		"2015-08-03 10:00:01\telixir \n" +
+		"2015-08-03 10:00:02\tELIXIR?\n" +
+		"2015-08-03 10:00:03\tElixir\n" +
+		"2015-08-03 10:00:04\t  \n" +
+		"2015-08-03 10:00:05\tgo\n"
+	if err := ioutil.WriteFile(file, []byte(logs), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	normalizer, err := NewNormalizer([]string{NormalizeCollapse, NormalizeFold, NormalizePunctuation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := datetree.Search{Year: 2015, Month: null.Int{Valid: true, Int: 8}, Popularity: 10}
+	tests := []struct {
+		display bool
+		want    []datetree.Popularity
+	}{
+		{false, []datetree.Popularity{{Query: "elixir", Count: 4}, {Query: "go", Count: 1}}},
+		{true, []datetree.Popularity{{Query: "Elixir", Count: 4}, {Query: "go", Count: 1}}},
+	}
+	for _, tc := range tests {
+		opts := IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}, Normalizer: normalizer, DisplayForms: tc.display}
+		i := &Index{Name: DefaultIndex, Files: []string{file}, Options: opts, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)), Metrics: NewMetrics()}
+		if err := i.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if r := i.Report(); r.Inserted != 5 || r.Ignored != 1 {
+			t.Errorf("wanted 5 hits inserted and the blank query ignored, got %+v", r)
+		}
+		if got := i.Tree().Popular(s); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("display forms %v: wanted %v, got %v", tc.display, tc.want, got)
+		}
+	}
+}
+
+func TestIngestFiltered(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "logs.tsv")
+	var logs strings.Builder
+	for n := 0; n < 5; n++ {
+		logs.WriteString("2015-08-03 10:00:00\tbot\n")
+	}
+	logs.WriteString("2015-08-03 10:00:01\tbot\n" +
+		"2015-08-03 10:00:02\tSPAM\n" +
+		"2015-08-03 10:00:03\t" + strings.Repeat("x", 51) + "\n" +
+		"2015-08-03 10:00:04\tgo\n")
+	if err := ioutil.WriteFile(file, []byte(logs.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules := filepath.Join(dir, "filter.txt")
+	if err := ioutil.WriteFile(rules, []byte("block spam\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	normalizer, _ := NewNormalizer([]string{NormalizeFold})
+	filter, err := NewFilter(rules, normalizer, 50, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}, Normalizer: normalizer, Filter: filter}
+	i := &Index{Name: DefaultIndex, Files: []string{file}, Options: opts, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)), Metrics: NewMetrics()}
+	if err := i.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{FilterBurst: 3, FilterBlocklist: 1, FilterLength: 1}
+	if r := i.Report(); r.Lines != 9 || r.Inserted != 4 || !reflect.DeepEqual(r.Filtered, want) {
+		t.Errorf("wanted 4 hits inserted and %v filtered, got %+v", want, r)
+	}
+	s := datetree.Search{Year: 2015, Popularity: 10}
+	if got := i.Tree().Popular(s); !reflect.DeepEqual(got, []datetree.Popularity{{Query: "bot", Count: 3}, {Query: "go", Count: 1}}) {
+		t.Errorf("wanted the hits kept to be indexed, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	i.Metrics.Registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `api_ingest_filtered_total{index="default",filter="burst"} 3`) {
+		t.Errorf("wanted the filtered hits in the metrics, got\n%s", w.Body)
+	}
+}
+
+func TestIngestBurstsAcrossFiles(t *testing.T) {
+	// three files have 2 hits of bot every second of 5 minutes, and a file of
+	// another year 2 hits of go, the bursts being counted across the files
+	// read at the same time
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	dir := t.TempDir()
+	write := func(name string, start time.Time, query string) string {
+		var logs strings.Builder
+		for s := 0; s < 300; s++ {
+			at := start.Add(time.Duration(s) * time.Second).Format(time.DateTime)
+			logs.WriteString(at + "\t" + query + "\n" + at + "\t" + query + "\n")
+		}
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(logs.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	start := time.Date(2015, 8, 3, 10, 0, 0, 0, time.UTC)
+	files := []string{
+		write("a.tsv", start, "bot"),
+		write("b.tsv", start, "bot"),
+		write("c.tsv", start, "bot"),
+		write("d.tsv", start.AddDate(1, 0, 0), "go"),
+	}
+
+	filter, err := NewFilter("", nil, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}, Filter: filter}
+	i := &Index{Name: DefaultIndex, Files: files, Options: opts, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)), Metrics: NewMetrics()}
+	want := map[string]int{FilterBurst: 900}
+	for n := 0; n < 5; n++ {
+		if err := i.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if r := i.Report(); r.Inserted != 1500 || !reflect.DeepEqual(r.Filtered, want) {
+			t.Fatalf("load %d: wanted 1500 hits inserted and %v filtered, got %+v", n, want, r)
+		}
+		s := datetree.Search{Year: 2015, Popularity: 10}
+		if got := i.Tree().Popular(s); !reflect.DeepEqual(got, []datetree.Popularity{{Query: "bot", Count: 900}}) {
+			t.Fatalf("load %d: wanted 3 hits of bot a second, got %v", n, got)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// DefaultIndex is the name of the index read from the file setting, and served
+// by the routes that do not name an index.
+const DefaultIndex = "default"
+
+// validIndexName matches the names allowed for indexes.
+var validIndexName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+const indexKey contextKey = "algolia.index"
+
+// GetIndex returns the index a request is made to, or nil if the route is not
+// specific to an index.
+func GetIndex(r *http.Request) *Index {
+	i, _ := r.Context().Value(indexKey).(*Index)
+	return i
+}
+
+// index returns the index a request is made to, the default one if the route
+// is not specific to an index.
+func (h *Handler) index(r *http.Request) *Index {
+	if i := GetIndex(r); i != nil {
+		return i
+	}
+	return h.Index
+}
+
+// indexes returns the indexes served, sorted by name.
+func (h *Handler) indexes() []*Index {
+	if len(h.Indexes) == 0 {
+		return []*Index{h.Index}
+	}
+
+	ret := make([]*Index, 0, len(h.Indexes))
+	for _, i := range h.Indexes {
+		ret = append(ret, i)
+	}
+	sort.Slice(ret, func(a, b int) bool { return ret[a].Name < ret[b].Name })
+	return ret
+}
+
+// IndexMiddleware is a middleware finding the index named by the index path
+// parameter, or the default index if the route has none, and inserting it into
+// the context to be used by the handlers.
+func (h *Handler) IndexMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := h.Index
+		if name := r.PathValue("index"); name != "" {
+			i = h.Indexes[name]
+			if i == nil {
+				h.Error(w, r, &Error{Status: http.StatusNotFound, Code: CodeIndexNotFound, Param: "index", Message: "no index named " + name})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), indexKey, i)))
+	})
+}
+
+// IndexInfo describes an index in the result of the ListIndexes route.
+type IndexInfo struct {
+	Name            string        `json:"name"`
+	Ready           bool          `json:"ready"`
+	Progress        *float64      `json:"progress,omitempty"`
+	Hits            int           `json:"hits"`
+	DistinctQueries int           `json:"distinct_queries"`
+	LastModified    *time.Time    `json:"last_modified,omitempty"`
+	LastLoad        *IngestReport `json:"last_load,omitempty"`
+}
+
+// IndexesResult is returned to the API user by the ListIndexes route.
+type IndexesResult struct {
+	Indexes []IndexInfo `json:"indexes"`
+}
+
+// ListIndexes is the handler responsible for the /1/indexes route. It lists
+// the indexes the API key can query, sorted by name.
+func (h *Handler) ListIndexes(w http.ResponseWriter, r *http.Request) {
+	k := GetAPIKey(r)
+	out := IndexesResult{Indexes: []IndexInfo{}}
+	for _, i := range h.indexes() {
+		if k != nil && !k.AllowsIndex(i.Name) {
+			continue
+		}
+
+		info := IndexInfo{Name: i.Name, Ready: i.Ready(), LastLoad: i.Report()}
+		if i.Loading() {
+			progress := i.Progress()
+			info.Progress = &progress
+		}
+		if tree := i.Tree(); tree != nil {
+			stats := tree.Stats()
+			modified := tree.LastModified().UTC()
+			info.Hits, info.DistinctQueries, info.LastModified = stats.Hits, stats.Queries, &modified
+		}
+		out.Indexes = append(out.Indexes, info)
+	}
+
+	h.Respond(w, r, out, http.StatusOK)
+}
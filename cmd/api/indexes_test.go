@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"tpaulmyer/algolia/datetree"
+)
+
+func TestIndexes(t *testing.T) {
+	other := datetree.NewTree()
+	other.Insert("docs", time.Date(2015, 8, 3, 10, 0, 0, 0, time.UTC))
+	other.Insert("docs", time.Date(2015, 8, 4, 10, 0, 0, 0, time.UTC))
+	other.IndexPopularity()
+
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+	h.Index.Name = DefaultIndex
+	h.Indexes = map[string]*Index{
+		DefaultIndex: h.Index,
+		"other":      {Name: "other"},
+		"loading":    {Name: "loading"},
+	}
+	h.Indexes["other"].SetTree(other)
+
+	rt := NewRouter()
+	rt.NotFound = http.HandlerFunc(h.NotFound)
+	for _, prefix := range []string{"/1/queries", "/1/indexes/{index}/queries"} {
+		rt.Handle(http.MethodGet, prefix+"/count/{date}", h.IndexMiddleware(h.ReadyMiddleware(h.DateMiddleware(http.HandlerFunc(h.Count)))))
+	}
+	rt.HandleFunc(http.MethodGet, "/1/indexes", h.ListIndexes)
+
+	do := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{"legacy route", "/1/queries/count/2015", 200, `{"count":7}`},
+		{"default index", "/1/indexes/default/queries/count/2015", 200, `{"count":7}`},
+		{"other index", "/1/indexes/other/queries/count/2015", 200, `{"count":1}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := do(tc.target)
+			if w.Code != tc.status || w.Body.String() != tc.body {
+				t.Errorf("wanted %d %s, got %d %s", tc.status, tc.body, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("unknown index", func(t *testing.T) {
+		var out APIError
+		w := do("/1/indexes/zorglub/queries/count/2015")
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusNotFound || out.Code != CodeIndexNotFound || out.Param != "index" {
+			t.Errorf("wanted an index_not_found error, got %d %+v", w.Code, out)
+		}
+	})
+
+	t.Run("loading index", func(t *testing.T) {
+		if w := do("/1/indexes/loading/queries/count/2015"); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("wanted status %d, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var out IndexesResult
+		w := do("/1/indexes")
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Indexes) != 3 {
+			t.Fatalf("wanted 3 indexes, got %s", w.Body.String())
+		}
+
+		want := []struct {
+			name    string
+			ready   bool
+			hits    int
+			queries int
+		}{
+			{DefaultIndex, true, 13, 7},
+			{"loading", false, 0, 0},
+			{"other", true, 2, 1},
+		}
+		for i, w := range want {
+			got := out.Indexes[i]
+			if got.Name != w.name || got.Ready != w.ready || got.Hits != w.hits || got.DistinctQueries != w.queries {
+				t.Errorf("wanted %+v, got %+v", w, got)
+			}
+			if w.ready && got.LastModified == nil {
+				t.Errorf("%s should have a modification date", w.name)
+			}
+		}
+	})
+}
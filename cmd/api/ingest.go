@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"tpaulmyer/algolia/datetree"
+)
+
+// Policies applied to the malformed lines of input files.
+const (
+	PolicySkip       = "skip"       // log and count them
+	PolicyFail       = "fail"       // abort the load
+	PolicyQuarantine = "quarantine" // also write them to a rejects file
+)
+
+// RejectsSuffix is appended to the name of an input file to get the file its
+// rejected lines are written to with the quarantine policy.
+const RejectsSuffix = ".rejects"
+
+// errIngestAborted is returned by the loads of files stopped because the load
+// of another file of the index failed.
+var errIngestAborted = errors.New("ingestion aborted")
+
+// IngestOptions configures how the input files of an index are read.
+type IngestOptions struct {
+	Source      SourceOptions
+	ErrorPolicy string // PolicySkip if empty
+
+	// Normalizer normalizes the queries before they are inserted, those
+	// normalized to an empty string being ignored. With DisplayForms, popular
+	// queries are returned in their most frequent original form.
+	Normalizer   *Normalizer
+	DisplayForms bool
+	Filter       *Filter // applied to the normalized queries, nil if none
+
+	// Follow keeps reading the uncompressed files after their end, the new
+	// lines being inserted in the served tree, whose popularity is refreshed
+	// every RefreshInterval.
+	Follow          bool
+	RefreshInterval time.Duration // DefaultRefreshInterval if zero
+	PollInterval    time.Duration // DefaultPollInterval if zero
+}
+
+// IngestReport summarizes the load of an index.
+type IngestReport struct {
+	Files        []string       `json:"files"`
+	Started      time.Time      `json:"started"`
+	Finished     time.Time      `json:"finished"`
+	Lines        int            `json:"lines"`
+	Inserted     int            `json:"inserted"`
+	Ignored      int            `json:"ignored"`            // lines without query, once normalized
+	Rejected     map[string]int `json:"rejected"`           // by kind of error
+	Filtered     map[string]int `json:"filtered,omitempty"` // by filter
+	RejectsFiles []string       `json:"rejects_files,omitempty"`
+	Error        string         `json:"error,omitempty"` // if the load failed
+}
+
+// add adds the counts of the report of a file to r.
+func (r *IngestReport) add(o *IngestReport) {
+	r.Lines += o.Lines
+	r.Inserted += o.Inserted
+	r.Ignored += o.Ignored
+	for k, n := range o.Rejected {
+		r.Rejected[k] += n
+	}
+	for k, n := range o.Filtered {
+		r.filtered(k, n)
+	}
+	for _, f := range o.RejectsFiles {
+		if !slices.Contains(r.RejectsFiles, f) {
+			r.RejectsFiles = append(r.RejectsFiles, f)
+		}
+	}
+}
+
+// filtered counts n hits rejected by a filter.
+func (r *IngestReport) filtered(filter string, n int) {
+	if r.Filtered == nil {
+		r.Filtered = map[string]int{}
+	}
+	r.Filtered[filter] += n
+}
+
+// LogValue logs the counts of the report.
+func (r *IngestReport) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("files", strings.Join(r.Files, ",")),
+		slog.Int("lines", r.Lines),
+		slog.Int("inserted", r.Inserted),
+		slog.Int("ignored", r.Ignored),
+		slog.Duration("duration", r.Finished.Sub(r.Started)),
+	}
+
+	kinds := make([]string, 0, len(r.Rejected))
+	for k := range r.Rejected {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		attrs = append(attrs, slog.Int("rejected_"+k, r.Rejected[k]))
+	}
+	filters := make([]string, 0, len(r.Filtered))
+	for k := range r.Filtered {
+		filters = append(filters, k)
+	}
+	sort.Strings(filters)
+	for _, k := range filters {
+		attrs = append(attrs, slog.Int("filtered_"+k, r.Filtered[k]))
+	}
+	if len(r.RejectsFiles) > 0 {
+		attrs = append(attrs, slog.String("rejects_files", strings.Join(r.RejectsFiles, ",")))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// ingest inserts the hits of a file read by r into tree, applying the error
+// policy of the index to malformed lines, which are appended to the rejects
+// file with the quarantine policy, and the filter of the index to queries, the
+// hits being counted with c. It stops with errIngestAborted once aborted is
+// set, and sets it when failing on a malformed line.
+func (i *Index) ingest(tree *datetree.Tree, c *burstCounter, file string, r RecordSource, report *IngestReport, aborted *atomic.Bool) error {
+	var rejects *os.File
+	defer func() {
+		if rejects != nil {
+			rejects.Close()
+		}
+	}()
+
+	m := i.Metrics
+	lines := m.IngestLines.With(i.Name)
+	for !aborted.Load() {
+		hit, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var lerr *LineError
+		if err != nil && err != ErrNoQuery && !errors.As(err, &lerr) {
+			return err
+		}
+
+		lines.Inc()
+		report.Lines++
+		if err == ErrNoQuery {
+			report.Ignored++
+			continue
+		}
+		if lerr == nil {
+			query := i.Options.Normalizer.Normalize(hit.Query)
+			if query == "" {
+				report.Ignored++
+				continue
+			}
+			if filter := i.Options.Filter.check(query, hit.Time, c); filter != "" {
+				report.filtered(filter, 1)
+				m.IngestFiltered.With(i.Name, filter).Inc()
+				continue
+			}
+			if i.Options.DisplayForms {
+				tree.InsertForm(query, hit.Query, hit.Time)
+			} else {
+				tree.Insert(query, hit.Time)
+			}
+			report.Inserted++
+			continue
+		}
+
+		report.Rejected[lerr.Kind]++
+		m.IngestErrors.With(i.Name, lerr.Kind).Inc()
+
+		switch i.Options.ErrorPolicy {
+		case PolicyFail:
+			aborted.Store(true)
+			return lerr
+		case PolicyQuarantine:
+			if rejects == nil {
+				report.RejectsFiles = append(report.RejectsFiles, file+RejectsSuffix)
+				if rejects, err = os.OpenFile(file+RejectsSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+					return err
+				}
+			}
+			if _, err := rejects.Write(lerr.Raw); err != nil {
+				return err
+			}
+		}
+		i.Logger.Warn("rejected line", "index", i.Name, "file", lerr.File, "line", lerr.Line, "kind", lerr.Kind, "err", lerr.Err)
+	}
+
+	return errIngestAborted
+}
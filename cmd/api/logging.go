@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"tpaulmyer/algolia/null"
+)
+
+// NewLogger returns a leveled logger writing to w. The format can be either
+// "json" or "logfmt", and the level one of "debug", "info", "warn" or "error".
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, errors.New("unknown log level " + level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "logfmt":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, errors.New("unknown log format " + format)
+	}
+}
+
+// RequestIDHeader is the header used to receive and return request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length above which a request ID sent by a client is
+// replaced by a generated one.
+const maxRequestIDLength = 128
+
+// RequestInfo gathers information on a request as it goes through the
+// middlewares and handlers, so that it can be logged once the response is sent.
+type RequestInfo struct {
+	ID     string
+	Route  string
+	Key    string // identifier of the API key
+	Layout string
+	Size   null.Int
+}
+
+const requestInfo contextKey = "algolia.requestinfo"
+
+// GetRequestInfo returns the RequestInfo of a request. Requests that did not go
+// through AccessLog get an empty RequestInfo that is not logged.
+func GetRequestInfo(r *http.Request) *RequestInfo {
+	if info, ok := r.Context().Value(requestInfo).(*RequestInfo); ok {
+		return info
+	}
+
+	return new(RequestInfo)
+}
+
+// AccessLog is a middleware that assigns an ID to each request, taken from the
+// X-Request-ID header if the client sent one, and logs a single line once the
+// response is sent.
+func (h *Handler) AccessLog(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &RequestInfo{ID: requestID(r), Route: route}
+		w.Header().Set(RequestIDHeader, info.ID)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfo, info))
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		attrs := []any{
+			"request_id", info.ID,
+			"method", r.Method,
+			"route", info.Route,
+			"path", r.URL.Path,
+			"status", sw.Status(),
+			"duration", time.Since(start),
+		}
+		if info.Key != "" {
+			attrs = append(attrs, "key", info.Key)
+		}
+		if info.Layout != "" {
+			attrs = append(attrs, "layout", info.Layout)
+		}
+		if info.Size.Valid {
+			attrs = append(attrs, "size", info.Size.Int)
+		}
+		h.Logger.Info("request", attrs...)
+	})
+}
+
+// RequestLogger returns a logger annotated with the ID of the request.
+func (h *Handler) RequestLogger(r *http.Request) *slog.Logger {
+	if id := GetRequestInfo(r).ID; id != "" {
+		return h.Logger.With("request_id", id)
+	}
+
+	return h.Logger
+}
+
+// requestID returns the request ID sent by the client, or a new one if it is
+// missing or unusable.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id != "" && len(id) <= maxRequestIDLength && strings.IndexFunc(id, invalidIDRune) < 0 {
+		return id
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func invalidIDRune(r rune) bool {
+	return r < '!' || r > '~'
+}
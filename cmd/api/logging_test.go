@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := Handler{
+		Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+		Index:  getIndexForTests(t),
+	}
+	popular := queryRouteForTests(&h, h.AccessLog("/1/queries/popular/", h.DateMiddleware(http.HandlerFunc(h.Popular))))
+
+	getLine := func(t *testing.T) map[string]interface{} {
+		var ret map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &ret); err != nil {
+			t.Fatalf("access log should be a single json line: %v", err)
+		}
+		buf.Reset()
+		return ret
+	}
+
+	t.Run("propagated request id", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/1/queries/popular/2015-08?size=3", nil)
+		r.Header.Set(RequestIDHeader, "my-request")
+		w := httptest.NewRecorder()
+
+		popular.ServeHTTP(w, r)
+		if id := w.Header().Get(RequestIDHeader); id != "my-request" {
+			t.Errorf("wanted request id my-request, got %s", id)
+		}
+		line := getLine(t)
+		want := map[string]interface{}{
+			"msg":        "request",
+			"request_id": "my-request",
+			"route":      "/1/queries/popular/",
+			"layout":     Month,
+			"size":       float64(3),
+			"status":     float64(http.StatusOK),
+		}
+		for k, v := range want {
+			if line[k] != v {
+				t.Errorf("wanted %s=%v, got %v", k, v, line[k])
+			}
+		}
+		if _, ok := line["duration"]; !ok {
+			t.Error("duration should be logged")
+		}
+	})
+
+	t.Run("generated request id", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/1/queries/popular/zorglub?size=3", nil)
+		r.Header.Set(RequestIDHeader, "invalid id with spaces")
+		w := httptest.NewRecorder()
+
+		popular.ServeHTTP(w, r)
+		id := w.Header().Get(RequestIDHeader)
+		if len(id) != 32 {
+			t.Errorf("a request id should have been generated, got %q", id)
+		}
+		line := getLine(t)
+		if line["request_id"] != id || line["status"] != float64(http.StatusBadRequest) {
+			t.Errorf("wrong access log line %v", line)
+		}
+		if _, ok := line["size"]; ok {
+			t.Error("size should not be logged when the request fails before reading it")
+		}
+	})
+}
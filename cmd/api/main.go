@@ -1,67 +1,193 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
-	"time"
-	"tpaulmyer/algolia/datetree"
+	"syscall"
 )
 
 func main() {
-	var port uint
-	var file string
-	flag.UintVar(&port, "p", 8080, "port the http server listen to")
-	flag.StringVar(&file, "f", "hn_logs.tsv", "TSV file to read from")
-	flag.Parse()
-
-	logger := log.New(os.Stdout, "api", log.LstdFlags)
-	f, err := os.Open(file)
+	cfg, printConfig, err := LoadConfig(os.Args[0], os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 	if err != nil {
-		logger.Fatalln("failed to open file:", err.Error())
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	logger.Println("reading file", file)
-	tsvr := NewTSVReader(f)
-	go func() {
-		for err := range tsvr.Error() {
-			logger.Println("error while reading tsv:", err.Error())
+	if printConfig {
+		if err := cfg.WriteTOML(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	}()
+		return
+	}
 
-	// Create new date tree and insert every line in it.
-	tree := datetree.NewTree()
-	var t time.Time
-	for l := range tsvr.Lines() {
-		if len(l) != 2 {
-			continue
-		}
+	logger, err := NewLogger(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid logging configuration:", err)
+		os.Exit(2)
+	}
 
-		t, err = time.Parse(Second, l[0])
+	m := NewMetrics()
+	comma, _ := parseDelimiter(cfg.Delimiter)     // validated with the configuration
+	normalizer, _ := NewNormalizer(cfg.Normalize) // validated with the configuration
+	filter, err := NewFilter(cfg.FilterFile, normalizer, cfg.MaxQueryLength, cfg.BurstLimit)
+	if err != nil {
+		logger.Error("failed to load filter", "err", err)
+		os.Exit(1)
+	}
+	opts := IngestOptions{
+		Source: SourceOptions{
+			Format:     cfg.Format,
+			TSV:        TSVOptions{Comma: comma, LazyQuotes: cfg.LazyQuotes, FieldsPerRecord: cfg.FieldsPerRecord},
+			TimeField:  cfg.TimeField,
+			QueryField: cfg.QueryField,
+			TimeFormat: cfg.TimeFormat,
+			QueryParam: cfg.QueryParam,
+		},
+		ErrorPolicy:     cfg.ErrorPolicy,
+		Normalizer:      normalizer,
+		DisplayForms:    cfg.DisplayForms,
+		Filter:          filter,
+		Follow:          cfg.Follow,
+		RefreshInterval: cfg.RefreshInterval,
+	}
+	index := &Index{Name: DefaultIndex, Files: cfg.Files, Options: opts, Logger: logger, Metrics: m}
+	indexes := map[string]*Index{DefaultIndex: index}
+	for _, spec := range cfg.Indexes {
+		name, file, _ := parseIndexSpec(spec) // validated with the configuration
+		indexes[name] = &Index{Name: name, Files: []string{file}, Options: opts, Logger: logger, Metrics: m}
+	}
+
+	// create handler
+	var h Handler
+	h.Logger = logger
+	h.Index = index
+	h.Indexes = indexes
+	h.CacheMaxAge = cfg.CacheMaxAge
+	h.RelativeTo = cfg.RelativeTo
+	h.Stop = make(chan struct{})
+	if cfg.CacheSize > 0 {
+		h.Cache = NewResponseCache(int(cfg.CacheSize))
+		m.RegisterCache(h.Cache)
+	}
+	if cfg.KeysFile != "" {
+		h.Keys, err = LoadKeys(cfg.KeysFile)
 		if err != nil {
-			logger.Printf("wrong date [%s] encountered in file: %s\n", l[0], err.Error())
-			continue
+			logger.Error("failed to load API keys", "err", err)
+			os.Exit(1)
 		}
+	}
 
-		tree.Insert(l[1], t)
+	// create router, every API route being measured and logged
+	router := NewRouter()
+	route := func(method, pattern string, handler http.Handler) {
+		router.Handle(method, pattern, m.Middleware(pattern, h.AccessLog(pattern, handler)))
+	}
+	limiters := map[string]*RateLimiter{
+		ACLCount:       NewRateLimiter(cfg.RateLimitCount, nil),
+		ACLPopular:     NewRateLimiter(cfg.RateLimitPopular, nil),
+		ACLListIndexes: NewRateLimiter(cfg.RateLimitList, nil),
+		ACLAdmin:       NewRateLimiter(cfg.RateLimitAdmin, nil),
 	}
+	ipLimiter := NewRateLimiter(cfg.RateLimitIP, nil)
+	protect := func(acl string, handler http.Handler) http.Handler {
+		return h.IPRateLimitMiddleware(ipLimiter, h.AuthMiddleware(acl, h.RateLimitMiddleware(limiters[acl], handler)))
+	}
+	query := func(route string, handler http.HandlerFunc) http.Handler {
+		return h.ReadyMiddleware(h.DateMiddleware(h.ETagMiddleware(h.CacheMiddleware(route, handler))))
+	}
+	// the legacy routes query the default index, the routes without date take
+	// from and to parameters
+	for _, prefix := range []string{"/1/queries", "/1/indexes/{index}/queries"} {
+		route(http.MethodGet, prefix+"/count", h.IndexMiddleware(protect(ACLCount, query("count", h.Count))))
+		route(http.MethodGet, prefix+"/count/{date}", h.IndexMiddleware(protect(ACLCount, query("count", h.Count))))
+		route(http.MethodGet, prefix+"/popular", h.IndexMiddleware(protect(ACLPopular, query("popular", h.Popular))))
+		route(http.MethodGet, prefix+"/popular/stream", h.IndexMiddleware(protect(ACLPopular, h.ReadyMiddleware(http.HandlerFunc(h.PopularStream)))))
+		route(http.MethodGet, prefix+"/popular/{date}", h.IndexMiddleware(protect(ACLPopular, query("popular", h.Popular))))
+	}
+	route(http.MethodGet, "/1/indexes", protect(ACLListIndexes, http.HandlerFunc(h.ListIndexes)))
+	route(http.MethodPost, "/1/admin/reload", protect(ACLAdmin, http.HandlerFunc(h.Reload)))
+	router.NotFound = m.Middleware("unmatched", h.AccessLog("unmatched", http.HandlerFunc(h.NotFound)))
+	router.MethodNotAllowed = m.Middleware("unmatched", h.AccessLog("unmatched", http.HandlerFunc(h.MethodNotAllowed)))
+	router.HandleFunc(http.MethodGet, "/healthz", h.Healthz)
+	router.HandleFunc(http.MethodGet, "/readyz", h.Readyz)
+	router.Handle(http.MethodGet, "/metrics", m.Registry)
 
-	logger.Println("indexing popularity")
-	tree.IndexPopularity()
-	logger.Printf("%d queries processed", tree.TotalCount)
+	// OPTIONS requests are answered once routed, unknown paths getting a 404
+	cors := CORSPolicy{
+		Origins: cfg.CORSOrigins,
+		Methods: cfg.CORSMethods,
+		Headers: cfg.CORSHeaders,
+		MaxAge:  cfg.CORSMaxAge,
+	}
+	router.Options = CORSPreflight(cors)
+	handler := CORSMiddleware(cors, router)
+	if cfg.CompressMinSize >= 0 {
+		handler = CompressMiddleware(int(cfg.CompressMinSize), handler)
+	}
 
-	// create handler
-	var h Handler
-	h.Logger = logger
-	h.DateTree = tree
+	srv := &http.Server{
+		Addr:    ":" + strconv.FormatUint(uint64(cfg.Port), 10),
+		Handler: handler,
+	}
+	// streams would otherwise keep the server from shutting down
+	srv.RegisterOnShutdown(func() { close(h.Stop) })
+
+	// SIGHUP reloads the dataset, SIGINT and SIGTERM stop the server once
+	// in-flight requests are done.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				logger.Info("reloading indexes", "signal", sig.String())
+				for _, i := range h.indexes() {
+					if err := i.Reload(); err != nil {
+						logger.Warn("cannot reload index", "index", i.Name, "err", err)
+					}
+				}
+				continue
+			}
+
+			logger.Info("shutting down server", "signal", sig.String())
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+			err := srv.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				logger.Error("failed to drain in-flight requests", "err", err)
+			}
+			return
+		}
+	}()
+
+	// Serve requests while the indexes are loading, the API routes answering
+	// with 503 until they are ready.
+	for _, i := range indexes {
+		go func(i *Index) {
+			if err := i.Load(); err != nil {
+				logger.Error("failed to load index", "index", i.Name, "files", i.Files, "err", err)
+				os.Exit(1)
+			}
+		}(i)
+	}
+
+	h.Logger.Info("server listening", "port", cfg.Port)
+	err = srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		h.Logger.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
 
-	// create server mux
-	mux := http.NewServeMux()
-	mux.Handle("/1/queries/count/", h.DateMiddleware(http.HandlerFunc(h.Count)))
-	mux.Handle("/1/queries/popular/", h.DateMiddleware(http.HandlerFunc(h.Popular)))
-	h.Logger.Println("server listening on port", port)
-	err = http.ListenAndServe(":"+strconv.FormatUint(uint64(port), 10), mux)
-	h.Logger.Fatal(err)
+	<-stopped
+	h.Logger.Info("server stopped")
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"tpaulmyer/algolia/datetree"
+	"tpaulmyer/algolia/metrics"
+)
+
+// Metrics groups the metrics exposed on the /metrics route.
+type Metrics struct {
+	Registry *metrics.Registry
+
+	Requests *metrics.CounterVec
+	Latency  *metrics.HistogramVec
+
+	TreeHits    *metrics.GaugeVec
+	TreeQueries *metrics.GaugeVec
+	TreeNodes   *metrics.GaugeVec
+
+	IngestLines    *metrics.CounterVec
+	IngestRead     *metrics.GaugeVec
+	IngestSize     *metrics.GaugeVec
+	IngestDone     *metrics.GaugeVec
+	IngestErrors   *metrics.CounterVec
+	IngestFiltered *metrics.CounterVec
+}
+
+// NewMetrics returns the metrics of the API registered in a new registry.
+func NewMetrics() *Metrics {
+	r := metrics.NewRegistry()
+	return &Metrics{
+		Registry: r,
+		Requests: r.NewCounterVec("api_http_requests_total",
+			"Number of HTTP requests handled, by route and status code.", "route", "status"),
+		Latency: r.NewHistogramVec("api_http_request_duration_seconds",
+			"Latency of HTTP requests, by route and status code.", metrics.DefBuckets, "route", "status"),
+		TreeHits: r.NewGaugeVec("api_tree_hits",
+			"Number of hits stored in the date tree, by index.", "index"),
+		TreeQueries: r.NewGaugeVec("api_tree_distinct_queries",
+			"Number of distinct queries stored in the date tree, by index.", "index"),
+		TreeNodes: r.NewGaugeVec("api_tree_nodes",
+			"Number of nodes in the date tree, by index and level.", "index", "level"),
+		IngestLines: r.NewCounterVec("api_ingest_lines_total",
+			"Number of lines read from the TSV file, by index.", "index"),
+		IngestRead: r.NewGaugeVec("api_ingest_read_bytes",
+			"Number of bytes of the TSV file read so far, by index.", "index"),
+		IngestSize: r.NewGaugeVec("api_ingest_size_bytes",
+			"Size of the TSV file being read, by index.", "index"),
+		IngestDone: r.NewGaugeVec("api_ingest_completed",
+			"Whether the TSV file has been fully read and indexed (1) or not (0), by index.", "index"),
+		IngestErrors: r.NewCounterVec("api_ingest_errors_total",
+			"Number of lines rejected while reading the TSV file, by index and kind of error.", "index", "kind"),
+		IngestFiltered: r.NewCounterVec("api_ingest_filtered_total",
+			"Number of hits filtered out while reading the input files, by index and filter.", "index", "filter"),
+	}
+}
+
+// RegisterCache exposes the statistics of the response cache.
+func (m *Metrics) RegisterCache(c *ResponseCache) {
+	stat := func(f func(CacheStats) float64) func() float64 {
+		return func() float64 { return f(c.Stats()) }
+	}
+	m.Registry.NewCounterFunc("api_cache_hits_total", "Number of responses served from the cache.",
+		stat(func(s CacheStats) float64 { return float64(s.Hits) }))
+	m.Registry.NewCounterFunc("api_cache_misses_total", "Number of responses not found in the cache.",
+		stat(func(s CacheStats) float64 { return float64(s.Misses) }))
+	m.Registry.NewCounterFunc("api_cache_evictions_total", "Number of responses evicted from the cache.",
+		stat(func(s CacheStats) float64 { return float64(s.Evictions) }))
+	m.Registry.NewGaugeFunc("api_cache_entries", "Number of responses in the cache.",
+		stat(func(s CacheStats) float64 { return float64(s.Entries) }))
+	m.Registry.NewGaugeFunc("api_cache_bytes", "Size of the responses in the cache.",
+		stat(func(s CacheStats) float64 { return float64(s.Bytes) }))
+}
+
+// SetTreeStats updates the tree size metrics of an index.
+func (m *Metrics) SetTreeStats(index string, s datetree.Stats) {
+	m.TreeHits.With(index).Set(float64(s.Hits))
+	m.TreeQueries.With(index).Set(float64(s.Queries))
+	m.TreeNodes.With(index, "year").Set(float64(s.Years))
+	m.TreeNodes.With(index, "week").Set(float64(s.Weeks))
+	m.TreeNodes.With(index, "month").Set(float64(s.Months))
+	m.TreeNodes.With(index, "day").Set(float64(s.Days))
+	m.TreeNodes.With(index, "hour").Set(float64(s.Hours))
+	m.TreeNodes.With(index, "minute").Set(float64(s.Minutes))
+	m.TreeNodes.With(index, "second").Set(float64(s.Seconds))
+}
+
+// Middleware counts the requests made to a route and measures their latency.
+func (m *Metrics) Middleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		status := strconv.Itoa(sw.Status())
+		m.Requests.With(route, status).Inc()
+		m.Latency.With(route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter is an http.ResponseWriter that remembers the status code sent to
+// the client.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Status returns the status code sent to the client.
+func (s *statusWriter) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
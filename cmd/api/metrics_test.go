@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+	m := NewMetrics()
+	m.SetTreeStats("default", h.Index.Tree().Stats())
+	count := queryRouteForTests(&h, m.Middleware("/1/queries/count/{date}", h.DateMiddleware(http.HandlerFunc(h.Count))))
+
+	for _, target := range []string{"/1/queries/count/2015", "/1/queries/count/2015-08", "/1/queries/count/zorglub"} {
+		count.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	w := httptest.NewRecorder()
+	m.Registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`api_http_requests_total{route="/1/queries/count/{date}",status="200"} 2`,
+		`api_http_requests_total{route="/1/queries/count/{date}",status="400"} 1`,
+		`api_http_request_duration_seconds_count{route="/1/queries/count/{date}",status="200"} 2`,
+		`api_tree_hits{index="default"} 13`,
+		`api_tree_distinct_queries{index="default"} 7`,
+		`api_tree_nodes{index="default",level="day"} 7`,
+		`api_tree_nodes{index="default",level="second"} 11`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics should contain %s", want)
+		}
+	}
+}
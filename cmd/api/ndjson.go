@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ndjsonSource reads files of JSON objects, one per line, holding the query
+// and the timestamp of a hit.
+type ndjsonSource struct {
+	lines       *lineReader
+	time, query string // keys
+	times       *timeParser
+}
+
+func newNDJSONSource(file string, r io.Reader, opts SourceOptions, from Position) *ndjsonSource {
+	ret := &ndjsonSource{lines: newLineReader(file, r, from), time: "time", query: "query", times: newSourceTimeParser(opts, from)}
+	if opts.TimeField != "" {
+		ret.time = opts.TimeField
+	}
+	if opts.QueryField != "" {
+		ret.query = opts.QueryField
+	}
+	return ret
+}
+
+func (n *ndjsonSource) Read() (Hit, error) {
+	raw, line, err := n.lines.next()
+	if err != nil {
+		return Hit{}, err
+	}
+
+	// numbers are kept as written for epoch timestamps
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return Hit{}, n.lines.error(raw, line, IngestErrorSyntax, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return Hit{}, n.lines.error(raw, line, IngestErrorSyntax, errors.New("data after the object"))
+	}
+	q, ok := obj[n.query].(string)
+	if !ok {
+		return Hit{}, n.lines.error(raw, line, IngestErrorFields, fmt.Errorf("no string %s", n.query))
+	}
+	var ts string
+	switch v := obj[n.time].(type) {
+	case string:
+		ts = v
+	case json.Number:
+		ts = v.String()
+	default:
+		return Hit{}, n.lines.error(raw, line, IngestErrorFields, fmt.Errorf("no string or number %s", n.time))
+	}
+
+	t, err := n.times.parse(ts)
+	if err != nil {
+		return Hit{}, n.lines.error(raw, line, IngestErrorDate, err)
+	}
+	return Hit{Line: line, Query: q, Time: t}, nil
+}
+
+func (n *ndjsonSource) Position() Position {
+	return Position{Line: n.lines.lines, TimeFormat: n.times.detected()}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Steps of the normalization of queries, applied in the configured order.
+const (
+	NormalizeURLDecode   = "urldecode"   // decode percent-encoded queries
+	NormalizeTrim        = "trim"        // remove leading and trailing white space
+	NormalizeCollapse    = "collapse"    // replace runs of white space by a space, and trim
+	NormalizeFold        = "fold"        // Unicode simple case folding
+	NormalizeNFC         = "nfc"         // Unicode normalization form C
+	NormalizePunctuation = "punctuation" // remove trailing sentence punctuation
+)
+
+// normalizeSteps are the functions of the normalization steps.
+var normalizeSteps = map[string]func(string) string{
+	NormalizeURLDecode:   urlDecode,
+	NormalizeTrim:        strings.TrimSpace,
+	NormalizeCollapse:    func(s string) string { return strings.Join(strings.Fields(s), " ") },
+	NormalizeFold:        func(s string) string { return strings.Map(foldRune, s) },
+	NormalizeNFC:         norm.NFC.String,
+	NormalizePunctuation: func(s string) string { return strings.TrimRight(s, trailingPunctuation) },
+}
+
+// NormalizeSteps lists the valid steps of the normalization of queries. The
+// steps are applied in the configured order, not in this one, which is only a
+// suggested order: decoding first lets the other steps see the white space
+// and combining marks of decoded queries.
+var NormalizeSteps = []string{NormalizeURLDecode, NormalizeTrim, NormalizeCollapse, NormalizeFold, NormalizeNFC, NormalizePunctuation}
+
+// trailingPunctuation is the punctuation removed from the end of queries.
+// Other punctuation is kept, for queries such as c# or "quoted".
+const trailingPunctuation = ".,;:!?…"
+
+// Normalizer normalizes the queries of input files before they are inserted
+// in a tree, so that queries written differently are counted together.
+type Normalizer struct {
+	steps []func(string) string
+}
+
+// NewNormalizer returns a normalizer applying steps in order, nil if there is
+// none.
+func NewNormalizer(steps []string) (*Normalizer, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	n := &Normalizer{}
+	for _, name := range steps {
+		step, ok := normalizeSteps[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown normalization step %q", name)
+		}
+		n.steps = append(n.steps, step)
+	}
+	return n, nil
+}
+
+// Normalize returns the normalized query, q if n is nil.
+func (n *Normalizer) Normalize(q string) string {
+	if n == nil {
+		return q
+	}
+	for _, step := range n.steps {
+		q = step(q)
+	}
+	return q
+}
+
+// urlDecode decodes the percent-encoded characters of a query, which is kept
+// as is if it is not validly encoded. Plus signs are kept, for queries such as
+// c++.
+func urlDecode(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	ret, err := url.PathUnescape(s)
+	if err != nil || !utf8.ValidString(ret) {
+		return s
+	}
+	return ret
+}
+
+// foldRune returns the simple case folding of r, as defined by the
+// CaseFolding.txt file of Unicode: the lower case of its upper case, σ for ς
+// or s for ſ, if they are the same letter, and the upper case of Cherokee
+// letters, which are folded to it for compatibility.
+func foldRune(r rune) rune {
+	if unicode.SimpleFold(r) == r {
+		return r // no other case, such as the dotless ı
+	}
+	if unicode.Is(unicode.Cherokee, r) {
+		return unicode.ToUpper(r)
+	}
+	return unicode.ToLower(unicode.ToUpper(r))
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestNormalizer(t *testing.T) {
+	tests := []struct {
+		steps []string
+		in    string
+		want  string
+	}{
+		{nil, " Elixir ", " Elixir "},
+		{[]string{NormalizeTrim}, " \tElixir  lang\n", "Elixir  lang"},
+		{[]string{NormalizeCollapse}, " \tElixir   lang\n", "Elixir lang"},
+		{[]string{NormalizeFold}, "ÉLIXIR Lang", "élixir lang"},
+		{[]string{NormalizeFold}, "ΟΔΥΣΣΕΥΣ οδυσσευς \u212a\u017f \u0131 \uab70\u13f8", "οδυσσευσ οδυσσευσ ks \u0131 \u13a0\u13f0"},
+		{[]string{NormalizeNFC}, "Cafe\u0301 cre\u0300me bru\u0302le\u0301e, Nt\u0308", "Caf\u00e9 cr\u00e8me br\u00fbl\u00e9e, N\u1e97"},
+		{[]string{NormalizeNFC}, "\u0301e x\u0301 N\u0303 \u1e9b\u0323 \u1100\u1161", "\u0301e x\u0301 \u00d1 \u1e9b\u0323 \uac00"},
+		{[]string{NormalizeURLDecode}, "caf%C3%A9+au%20lait", "café+au lait"},
+		{[]string{NormalizeURLDecode}, "100% go", "100% go"},
+		{[]string{NormalizeURLDecode}, "%ff", "%ff"},
+		{[]string{NormalizePunctuation}, "what is go?!...", "what is go"},
+		{[]string{NormalizePunctuation}, "c# (book)", "c# (book)"},
+		{NormalizeSteps, "  Cafe%CC%81   au lait?? ", "café au lait"},
+		{[]string{NormalizeURLDecode, NormalizeTrim}, "%20go%20", "go"},
+		{[]string{NormalizeTrim, NormalizeURLDecode}, "%20go%20", " go "},
+	}
+	for _, tc := range tests {
+		n, err := NewNormalizer(tc.steps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := n.Normalize(tc.in); got != tc.want {
+			t.Errorf("%v: %q: wanted %q, got %q", tc.steps, tc.in, tc.want, got)
+		}
+	}
+
+	if _, err := NewNormalizer([]string{NormalizeTrim, "nfkc"}); err == nil {
+		t.Error("unknown steps should be refused")
+	}
+}
@@ -1,43 +1,77 @@
 package main
 
 import (
-	"errors"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
+	"time"
+	"tpaulmyer/algolia/null"
 )
 
-// GetDateFromURL returns the date specified in the URL.
-func GetDateFromURL(r *http.Request) (string, error) {
-	if strings.Count(r.URL.Path, "/") != 4 {
-		return "", errors.New("url badly formatted")
-	}
-
-	i := strings.LastIndex(r.URL.Path, "/")
-	if i == len(r.URL.Path)-1 {
-		return "", errors.New("no date specified in url")
-	}
-
-	// unescape the string, in case there are spaces (%20 in an encoded URL)
-	return url.PathUnescape(r.URL.Path[i+1:])
-}
-
 // GetSizeParameter returns the size parameter for the popularity route.
 func GetSizeParameter(r *http.Request) (int, error) {
 	s := r.URL.Query().Get("size")
 	if s == "" {
-		return 0, errors.New("missing size parameter")
+		return 0, &Error{Status: http.StatusBadRequest, Code: CodeMissingParameter, Param: "size", Message: "missing size parameter"}
 	}
 
 	size, err := strconv.Atoi(s)
 	if err != nil {
-		return 0, errors.New("size parameter invalid: " + err.Error())
+		return 0, &Error{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Param: "size", Message: "size parameter invalid: " + err.Error()}
 	}
 
 	if size < 0 {
-		return 0, errors.New("size parameter cannot be negative")
+		return 0, &Error{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Param: "size", Message: "size parameter cannot be negative"}
 	}
 
 	return size, nil
 }
+
+// GetCycleParameters returns the weekday and hour parameters, restricting the
+// queries of a year to a day of the week or an hour of the day in UTC. Days are
+// given by their English name, its first three letters or their ISO 8601
+// number, 1 for Monday to 7 for Sunday.
+func GetCycleParameters(r *http.Request) (weekday, hour null.Int, err error) {
+	q := r.URL.Query()
+	if q.Get("weekday") != "" && q.Get("hour") != "" {
+		return null.Int{}, null.Int{}, &Error{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Param: "hour", Message: "weekday and hour parameters cannot be combined"}
+	}
+
+	if s := q.Get("weekday"); s != "" {
+		d, ok := parseWeekday(s)
+		if !ok {
+			return null.Int{}, null.Int{}, &Error{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Param: "weekday", Message: "weekday parameter invalid: " + s}
+		}
+		weekday = null.Int{Valid: true, Int: int(d)}
+	}
+
+	if s := q.Get("hour"); s != "" {
+		h, err := strconv.Atoi(s)
+		if err != nil || h < 0 || h > 23 {
+			return null.Int{}, null.Int{}, &Error{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Param: "hour", Message: "hour parameter must be between 0 and 23"}
+		}
+		hour = null.Int{Valid: true, Int: h}
+	}
+
+	return weekday, hour, nil
+}
+
+// parseWeekday parses a day of the week.
+func parseWeekday(s string) (time.Weekday, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 || n > 7 {
+			return 0, false
+		}
+		return time.Weekday(n % 7), true
+	}
+
+	s = strings.ToLower(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock gives the current time. It is an interface so that tests can control
+// time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// RateLimit is the rate at which requests are allowed, with bursts of up to
+// Burst requests.
+type RateLimit struct {
+	Rate  float64 // requests per second
+	Burst int
+}
+
+// ParseRateLimit parses a rate limit written as "<requests>/<unit>[:<burst>]",
+// the unit being s, m or h, e.g. "10/s:20". The burst defaults to the number
+// of requests. An empty string means no limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" {
+		return RateLimit{}, nil
+	}
+
+	var burst string
+	if i := strings.Index(s, ":"); i >= 0 {
+		s, burst = s[:i], s[i+1:]
+	}
+
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return RateLimit{}, errors.New("rate limit must be written <requests>/<unit>[:<burst>]")
+	}
+
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n <= 0 {
+		return RateLimit{}, errors.New("invalid number of requests " + s[:i])
+	}
+
+	var unit time.Duration
+	switch s[i+1:] {
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	default:
+		return RateLimit{}, errors.New("invalid rate limit unit " + s[i+1:])
+	}
+
+	ret := RateLimit{Rate: float64(n) / unit.Seconds(), Burst: n}
+	if burst != "" {
+		ret.Burst, err = strconv.Atoi(burst)
+		if err != nil || ret.Burst <= 0 {
+			return RateLimit{}, errors.New("invalid burst " + burst)
+		}
+	}
+
+	return ret, nil
+}
+
+// String formats the rate limit the way ParseRateLimit reads it.
+func (l RateLimit) String() string {
+	if !l.Enabled() {
+		return ""
+	}
+
+	for _, u := range []struct {
+		name string
+		d    time.Duration
+	}{{"s", time.Second}, {"m", time.Minute}, {"h", time.Hour}} {
+		n := l.Rate * u.d.Seconds()
+		if n >= 1 && math.Abs(n-math.Round(n)) < 1e-9 {
+			return strconv.Itoa(int(math.Round(n))) + "/" + u.name + ":" + strconv.Itoa(l.Burst)
+		}
+	}
+
+	return strconv.Itoa(int(math.Round(l.Rate*3600))) + "/h:" + strconv.Itoa(l.Burst)
+}
+
+// Enabled reports whether requests are limited.
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// RateLimiter limits requests with a token bucket per client.
+type RateLimiter struct {
+	Limit RateLimit
+	Clock Clock
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is the interval at which buckets of idle clients are removed.
+const sweepInterval = time.Minute
+
+// NewRateLimiter returns a rate limiter. The clock defaults to the system
+// clock if nil.
+func NewRateLimiter(limit RateLimit, clock Clock) *RateLimiter {
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return &RateLimiter{
+		Limit:     limit,
+		Clock:     clock,
+		buckets:   map[string]*bucket{},
+		lastSweep: clock.Now(),
+	}
+}
+
+// RateLimitResult is the outcome of a rate limiter check.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // time before a request is allowed again
+	Reset      time.Duration // time before the bucket is full again
+}
+
+// Allow takes a token from the bucket of the client identified by key.
+func (l *RateLimiter) Allow(key string) RateLimitResult {
+	now := l.Clock.Now()
+	burst := float64(l.Limit.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*l.Limit.Rate)
+	}
+	b.last = now
+
+	var ret RateLimitResult
+	if b.tokens >= 1 {
+		b.tokens--
+		ret.Allowed = true
+	} else {
+		ret.RetryAfter = l.duration(1 - b.tokens)
+	}
+
+	ret.Remaining = int(b.tokens)
+	ret.Reset = l.duration(burst - b.tokens)
+	return ret
+}
+
+// duration returns the time needed to refill n tokens.
+func (l *RateLimiter) duration(n float64) time.Duration {
+	return time.Duration(math.Ceil(n / l.Limit.Rate * float64(time.Second)))
+}
+
+// sweep removes the buckets that are full, which is the same as not having a
+// bucket.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Limit.Rate >= float64(l.Limit.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// RateLimitMiddleware is a middleware limiting the rate of requests of each
+// client, identified by its API key if the request is authenticated or by its
+// IP address otherwise. Secured keys share the limit of the key they are
+// derived from, as anyone holding a key can derive as many as they want.
+// Requests are not limited if l is nil.
+func (h *Handler) RateLimitMiddleware(l *RateLimiter, next http.Handler) http.Handler {
+	return h.rateLimit(l, func(r *http.Request) string {
+		if k := GetAPIKey(r); k != nil {
+			return "key:" + k.Root().ID()
+		}
+		return "ip:" + clientIP(r)
+	}, next)
+}
+
+// IPRateLimitMiddleware is a middleware limiting the rate of requests of each
+// IP address, whether the request is authenticated or not. It is applied
+// before authentication, so that failed authentications are limited too.
+// Requests are not limited if l is nil.
+func (h *Handler) IPRateLimitMiddleware(l *RateLimiter, next http.Handler) http.Handler {
+	return h.rateLimit(l, func(r *http.Request) string { return "ip:" + clientIP(r) }, next)
+}
+
+// rateLimit limits the rate of requests of the clients identified by key.
+func (h *Handler) rateLimit(l *RateLimiter, key func(*http.Request) string, next http.Handler) http.Handler {
+	if l == nil || !l.Limit.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := l.Allow(key(r))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.Limit.Burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			h.Error(w, r, &Error{Status: http.StatusTooManyRequests, Code: CodeRateLimited, Message: "too many requests"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientIP returns the IP address of the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only changes when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want RateLimit
+		err  bool
+	}{
+		{in: "", want: RateLimit{}},
+		{in: "10/s", want: RateLimit{Rate: 10, Burst: 10}},
+		{in: "60/m:5", want: RateLimit{Rate: 1, Burst: 5}},
+		{in: "3600/h", want: RateLimit{Rate: 1, Burst: 3600}},
+		{in: "10", err: true},
+		{in: "0/s", err: true},
+		{in: "10/d", err: true},
+		{in: "10/s:0", err: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseRateLimit(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: wanted %+v, got %+v", tc.in, tc.want, got)
+		}
+		if !tc.err {
+			if back, _ := ParseRateLimit(got.String()); back != got {
+				t.Errorf("%q: formatting should be reversible, got %q", tc.in, got.String())
+			}
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter(RateLimit{Rate: 2, Burst: 3}, clock)
+
+	for i := 2; i >= 0; i-- {
+		res := l.Allow("a")
+		if !res.Allowed || res.Remaining != i {
+			t.Fatalf("request should be allowed with %d remaining, got %+v", i, res)
+		}
+	}
+
+	res := l.Allow("a")
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond || res.Reset != 1500*time.Millisecond {
+		t.Errorf("request should be rejected, got %+v", res)
+	}
+	if !l.Allow("b").Allowed {
+		t.Error("buckets should be per client")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if res := l.Allow("a"); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("a token should have been refilled, got %+v", res)
+	}
+
+	clock.Advance(time.Hour)
+	if res := l.Allow("a"); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("bucket should be full, got %+v", res)
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("idle buckets should have been swept, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+	clock := &fakeClock{now: time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, clock)
+	count := queryRouteForTests(&h, h.RateLimitMiddleware(l, h.DateMiddleware(http.HandlerFunc(h.Count))))
+
+	do := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/1/queries/count/2015", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		count.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("10.0.0.1:1234")
+	if w.Code != http.StatusOK {
+		t.Fatalf("wanted status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "1" || w.Header().Get("X-RateLimit-Remaining") != "0" || w.Header().Get("X-RateLimit-Reset") != "1" {
+		t.Errorf("wrong rate limit headers %v", w.Header())
+	}
+
+	w = do("10.0.0.1:4321")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("wanted status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("wanted Retry-After 1, got %q", w.Header().Get("Retry-After"))
+	}
+
+	if w := do("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("other clients should not be limited, got status %d", w.Code)
+	}
+
+	clock.Advance(time.Second)
+	if w := do("10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("client should be allowed again, got status %d", w.Code)
+	}
+}
+
+func TestRateLimitAuthenticated(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	if err := ioutil.WriteFile(file, []byte(sampleKeys), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeys(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+		Keys:   keys,
+	}
+	clock := &fakeClock{now: time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC)}
+
+	do := func(handler http.Handler, addr, key string) int {
+		r := httptest.NewRequest("GET", "/1/queries/count/2015", nil)
+		r.RemoteAddr = addr
+		r.Header.Set(ApplicationIDHeader, "APPID")
+		r.Header.Set(APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	t.Run("secured keys share the limit of their parent", func(t *testing.T) {
+		l := NewRateLimiter(RateLimit{Rate: 1, Burst: 2}, clock)
+		count := queryRouteForTests(&h, h.AuthMiddleware(ACLCount, h.RateLimitMiddleware(l, h.DateMiddleware(http.HandlerFunc(h.Count)))))
+		var codes []int
+		for n := 0; n < 3; n++ {
+			secured := GenerateSecuredKey("search-key", url.Values{"n": {strconv.Itoa(n)}})
+			codes = append(codes, do(count, "10.0.0.1:1234", secured))
+		}
+		if want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}; !reflect.DeepEqual(codes, want) {
+			t.Errorf("wanted statuses %v, got %v", want, codes)
+		}
+		if code := do(count, "10.0.0.1:1234", "admin-key"); code != http.StatusOK {
+			t.Errorf("other keys should not be limited, got status %d", code)
+		}
+	})
+
+	t.Run("failed authentications are limited by IP", func(t *testing.T) {
+		l := NewRateLimiter(RateLimit{Rate: 1, Burst: 2}, clock)
+		count := queryRouteForTests(&h, h.IPRateLimitMiddleware(l, h.AuthMiddleware(ACLCount, h.DateMiddleware(http.HandlerFunc(h.Count)))))
+		var codes []int
+		for _, key := range []string{"guess-1", "guess-2", "guess-3", "search-key"} {
+			codes = append(codes, do(count, "10.0.0.2:1234", key))
+		}
+		if want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests}; !reflect.DeepEqual(codes, want) {
+			t.Errorf("wanted statuses %v, got %v", want, codes)
+		}
+		if code := do(count, "10.0.0.3:1234", "search-key"); code != http.StatusOK {
+			t.Errorf("other addresses should not be limited, got status %d", code)
+		}
+	})
+}
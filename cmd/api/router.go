@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests by method and path. Patterns are made of static
+// segments and of {name} segments matching any single non-empty segment, whose
+// unescaped value is available to handlers with r.PathValue(name), e.g.
+// "/1/queries/count/{date}". Static segments take precedence over parameters,
+// so that specific routes, or routes of a new API version, can be registered
+// next to generic ones.
+type Router struct {
+	// NotFound handles requests matching no route, and MethodNotAllowed the
+	// ones matching routes of other methods only, once the Allow header is
+	// set. They default to plain text errors.
+	NotFound         http.Handler
+	MethodNotAllowed http.Handler
+	// Options, if set, handles the OPTIONS requests matching routes of other
+	// methods, once the Allow header is set, e.g. to answer CORS preflight
+	// requests. OPTIONS requests to unknown paths are still handled by
+	// NotFound.
+	Options http.Handler
+
+	routes []*routerEntry
+}
+
+type routerEntry struct {
+	method   string
+	segments []string
+	handler  http.Handler
+}
+
+// NewRouter returns a router without routes.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers the handler for requests of the given method and path
+// pattern. GET handlers also serve HEAD requests.
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("router: pattern " + pattern + " should start with /")
+	}
+
+	for _, e := range rt.routes {
+		if e.method == method && strings.Join(e.segments, "/") == pattern {
+			panic("router: duplicate route " + method + " " + pattern)
+		}
+	}
+
+	rt.routes = append(rt.routes, &routerEntry{
+		method:   method,
+		segments: strings.Split(pattern, "/"),
+		handler:  handler,
+	})
+}
+
+// HandleFunc registers the handler function for requests of the given method
+// and path pattern.
+func (rt *Router) HandleFunc(method, pattern string, handler http.HandlerFunc) {
+	rt.Handle(method, pattern, handler)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(r.URL.EscapedPath(), "/")
+
+	var best *routerEntry
+	var bestParams map[string]string
+	allowed := map[string]bool{}
+	for _, e := range rt.routes {
+		params, ok := e.match(segments)
+		if !ok {
+			continue
+		}
+		if e.method != r.Method && (e.method != http.MethodGet || r.Method != http.MethodHead) {
+			allowed[e.method] = true
+			continue
+		}
+		if best == nil || e.precedes(best) {
+			best, bestParams = e, params
+		}
+	}
+
+	if best != nil {
+		for name, value := range bestParams {
+			r.SetPathValue(name, value)
+		}
+		best.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if len(allowed) == 0 {
+		handler := rt.NotFound
+		if handler == nil {
+			handler = http.NotFoundHandler()
+		}
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	if allowed[http.MethodGet] {
+		allowed[http.MethodHead] = true
+	}
+	if rt.Options != nil {
+		allowed[http.MethodOptions] = true
+	}
+	methods := make([]string, 0, len(allowed))
+	for m := range allowed {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+
+	if rt.Options != nil && r.Method == http.MethodOptions {
+		rt.Options.ServeHTTP(w, r)
+		return
+	}
+
+	handler := rt.MethodNotAllowed
+	if handler == nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		})
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// match returns the unescaped path parameters if the escaped path segments
+// match the pattern of the route.
+func (e *routerEntry) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(e.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, s := range e.segments {
+		name, ok := paramName(s)
+		if !ok {
+			if segments[i] != s {
+				return nil, false
+			}
+			continue
+		}
+
+		value, err := url.PathUnescape(segments[i])
+		if err != nil || value == "" {
+			return nil, false
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[name] = value
+	}
+
+	return params, true
+}
+
+// precedes reports whether the route takes precedence over other, both
+// matching the same path: the first segment where one is static and the
+// other a parameter decides.
+func (e *routerEntry) precedes(other *routerEntry) bool {
+	for i, s := range e.segments {
+		_, param := paramName(s)
+		_, otherParam := paramName(other.segments[i])
+		if param != otherParam {
+			return otherParam
+		}
+	}
+	return false
+}
+
+// paramName returns the name of a {name} pattern segment.
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// NotFound answers requests to unknown routes.
+func (h *Handler) NotFound(w http.ResponseWriter, r *http.Request) {
+	h.Error(w, r, &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: "no route for " + r.URL.Path})
+}
+
+// MethodNotAllowed answers requests to known routes with unsupported methods.
+func (h *Handler) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	h.Error(w, r, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Code:    CodeMethodNotAllowed,
+		Message: "method " + r.Method + " not allowed, expected " + w.Header().Get("Allow"),
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// queryRouteForTests serves next on the query routes, with and without the
+// date path parameter.
+func queryRouteForTests(h *Handler, next http.Handler) http.Handler {
+	rt := NewRouter()
+	rt.NotFound = http.HandlerFunc(h.NotFound)
+	rt.MethodNotAllowed = http.HandlerFunc(h.MethodNotAllowed)
+	rt.Handle(http.MethodGet, "/{version}/queries/{kind}", next)
+	rt.Handle(http.MethodGet, "/{version}/queries/{kind}/{date}", next)
+	return rt
+}
+
+func TestRouter(t *testing.T) {
+	h := Handler{Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil))}
+
+	rt := NewRouter()
+	rt.NotFound = http.HandlerFunc(h.NotFound)
+	rt.MethodNotAllowed = http.HandlerFunc(h.MethodNotAllowed)
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.PathValue("kind") + " " + r.PathValue("date")))
+		})
+	}
+	rt.Handle(http.MethodGet, "/1/queries/{kind}/{date}", handler("generic"))
+	rt.Handle(http.MethodGet, "/1/queries/count/{date}", handler("count"))
+	rt.Handle(http.MethodGet, "/2/queries/{kind}/{date}", handler("v2"))
+	rt.Handle(http.MethodPost, "/1/admin/reload", handler("reload"))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+		body   string
+		allow  string
+	}{
+		{"static segment first", "GET", "/1/queries/count/2015", 200, "count  2015", ""},
+		{"parameters", "GET", "/1/queries/popular/2015-08-01%2012:00", 200, "generic popular 2015-08-01 12:00", ""},
+		{"head", "HEAD", "/1/queries/popular/2015", 200, "generic popular 2015", ""},
+		{"other version", "GET", "/2/queries/count/2015", 200, "v2 count 2015", ""},
+		{"missing parameter", "GET", "/1/queries/count/", 404, "no route for /1/queries/count/", ""},
+		{"too many segments", "GET", "/1/queries/count/2015/08", 404, "no route for /1/queries/count/2015/08", ""},
+		{"unknown path", "GET", "/zorglub", 404, "no route for /zorglub", ""},
+		{"wrong method", "DELETE", "/1/queries/count/2015", 405, "method DELETE not allowed, expected GET, HEAD", "GET, HEAD"},
+		{"post only", "GET", "/1/admin/reload", 405, "method GET not allowed, expected POST", "POST"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, nil))
+			body := w.Body.String()
+			if w.Code >= 400 {
+				var out APIError
+				if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+					t.Fatal(err)
+				}
+				body = out.Error
+			}
+			if w.Code != tc.status || body != tc.body {
+				t.Errorf("wanted %d %s, got %d %s", tc.status, tc.body, w.Code, body)
+			}
+			if allow := w.Header().Get("Allow"); allow != tc.allow {
+				t.Errorf("wanted Allow %q, got %q", tc.allow, allow)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"tpaulmyer/algolia/internal/zstd"
+)
+
+// Stdin is the input file name designating the standard input.
+const Stdin = "-"
+
+// ErrStdinConsumed is returned when loading an index from the standard input
+// a second time.
+var ErrStdinConsumed = errors.New("the standard input has already been read")
+
+// sourceName returns the name of an input file in logs and errors. The
+// rejected lines of the standard input are thus written to stdin.rejects.
+func sourceName(file string) string {
+	if file == Stdin {
+		return "stdin"
+	}
+	return file
+}
+
+// expandSources returns the files designated by the input patterns, in order
+// and without duplicates. A pattern can be a file, the standard input, a
+// directory or a glob, whose matches are restricted to regular files other
+// than hidden and rejects files.
+func expandSources(patterns []string) ([]string, error) {
+	var ret []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			ret = append(ret, file)
+		}
+	}
+
+	for _, p := range patterns {
+		switch fi, err := os.Stat(p); {
+		case p == Stdin:
+			add(p)
+		case err == nil && fi.IsDir():
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Type().IsRegular() && isInput(e.Name()) {
+					add(filepath.Join(p, e.Name()))
+				}
+			}
+		case err != nil && strings.ContainsAny(p, "*?["):
+			matches, err := filepath.Glob(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no file matches the pattern", p)
+			}
+			sort.Strings(matches)
+			for _, m := range matches {
+				if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() && isInput(filepath.Base(m)) {
+					add(m)
+				}
+			}
+		default:
+			add(p)
+		}
+	}
+
+	return ret, nil
+}
+
+// isInput reports whether a file found in a directory or with a glob is read.
+func isInput(name string) bool {
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, RejectsSuffix)
+}
+
+// codec is a compression format of input files.
+type codec struct {
+	extension string
+	magic     []byte
+	reader    func(io.Reader) (io.Reader, error)
+}
+
+var codecs = []codec{
+	{".gz", []byte{0x1f, 0x8b}, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	{".bz2", []byte("BZh"), func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }},
+	{".zst", []byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r), nil }},
+}
+
+// decompress returns a reader of the decompressed content of the file read by
+// r, detecting its compression from its first bytes or else its extension, and
+// whether it is compressed.
+func decompress(name string, r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4)
+	for _, c := range codecs {
+		if bytes.HasPrefix(head, c.magic) {
+			d, err := c.reader(br)
+			return d, true, err
+		}
+	}
+	for _, c := range codecs {
+		if strings.HasSuffix(name, c.extension) {
+			d, err := c.reader(br)
+			return d, true, err
+		}
+	}
+
+	return br, false, nil
+}
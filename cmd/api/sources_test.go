@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"tpaulmyer/algolia/datetree"
+)
+
+// bzip2Data is "2015-08-01 00:03:43\tbzip2\n" compressed with bzip2, which has
+// no encoder in the standard library.
+const bzip2Data = "425a6839314159265359dcc14de00000075980003040027e501020401020" +
+	"00314c0013428d1a7a8f2268002471d31bf9465cb5945557c5dc914e14243730537800"
+
+// zstdData is "2015-08-01 00:03:43\tzstd\n" repeated 20 times, compressed with
+// zstd -19, which has no encoder in the standard library.
+const zstdData = "28b52ffd0468050100c8323031352d30382d30312030303a30333a3433097a7374640a" +
+	"010061b33ac7147728be"
+
+func gzipData(t *testing.T, s string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestExpandSources(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.tsv", "b.tsv.gz", "a.tsv" + RejectsSuffix, ".hidden", "sub/c.tsv"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		err      string
+	}{
+		{"file", []string{path("a.tsv")}, []string{path("a.tsv")}, ""},
+		{"missing file", []string{path("missing.tsv")}, []string{path("missing.tsv")}, ""},
+		{"stdin", []string{Stdin, path("a.tsv")}, []string{Stdin, path("a.tsv")}, ""},
+		{"directory", []string{dir}, []string{path("a.tsv"), path("b.tsv.gz")}, ""},
+		{"glob", []string{path("*"), path("sub/*.tsv")}, []string{path("a.tsv"), path("b.tsv.gz"), path("sub/c.tsv")}, ""},
+		{"duplicates", []string{path("a.tsv"), dir}, []string{path("a.tsv"), path("b.tsv.gz")}, ""},
+		{"no match", []string{path("*.csv")}, nil, "no file matches the pattern"},
+		{"bad pattern", []string{path("[")}, nil, "syntax error in pattern"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := expandSources(tc.patterns)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("wanted error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wanted %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	line := "2015-08-01 00:03:43\tbzip2\n"
+	bz, err := hex.DecodeString(bzip2Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zs, err := hex.DecodeString(zstdData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		input      []byte
+		want       string
+		compressed bool
+		err        string
+	}{
+		{"plain", "logs.tsv", []byte(line), line, false, ""},
+		{"empty", "logs.tsv", nil, "", false, ""},
+		{"gzip", "logs.tsv.gz", gzipData(t, line), line, true, ""},
+		{"gzip without extension", "logs.tsv", gzipData(t, line), line, true, ""},
+		{"bzip2", "logs", bz, line, true, ""},
+		{"zstd", "logs.tsv.zst", zs, strings.Repeat("2015-08-01 00:03:43\tzstd\n", 20), true, ""},
+		{"truncated zstd", "logs.tsv", zs[:20], "", true, "zstd decompression error"},
+		{"wrong extension", "logs.tsv.gz", []byte(line), "", true, "gzip: invalid header"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, compressed, err := decompress(tc.file, bytes.NewReader(tc.input))
+			var got []byte
+			if err == nil {
+				got, err = io.ReadAll(r)
+			}
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("wanted error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want || compressed != tc.compressed {
+				t.Errorf("wanted %q compressed %v, got %q compressed %v", tc.want, tc.compressed, got, compressed)
+			}
+		})
+	}
+}
+
+func TestIndexSources(t *testing.T) {
+	dir := t.TempDir()
+	lines := strings.SplitAfter(strings.TrimPrefix(sampleData, "\n"), "\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.tsv"), []byte(strings.Join(lines[:5], "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.tsv.gz"), gzipData(t, strings.Join(lines[5:10], "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "c.tsv")
+	if err := ioutil.WriteFile(other, []byte(strings.Join(lines[10:], "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old *os.File) { os.Stdin = old }(os.Stdin)
+	os.Stdin = stdin
+	go func() {
+		w.WriteString("2015-09-10 11:05:11\tstdin\n")
+		w.Close()
+	}()
+
+	opts := IngestOptions{Source: SourceOptions{TSV: TSVOptions{FieldsPerRecord: 2}}}
+	i := &Index{Name: DefaultIndex, Files: []string{dir, other, Stdin}, Options: opts, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)), Metrics: NewMetrics()}
+	if err := i.Load(); err != nil {
+		t.Fatal(err)
+	}
+	tree := i.Tree()
+	if tree.TotalCount != 14 {
+		t.Errorf("wanted 14 hits, got %d", tree.TotalCount)
+	}
+	if n := tree.Count(datetree.Search{Year: 2015}); n != 8 {
+		t.Errorf("wanted 8 distinct queries, got %d", n)
+	}
+	want := []string{filepath.Join(dir, "a.tsv"), filepath.Join(dir, "b.tsv.gz"), other, Stdin}
+	if report := i.Report(); !reflect.DeepEqual(report.Files, want) || report.Inserted != 14 {
+		t.Errorf("wanted 14 hits inserted from %v, got %+v", want, report)
+	}
+
+	if err := i.Load(); err != ErrStdinConsumed {
+		t.Errorf("wanted error %v reloading the standard input, got %v", ErrStdinConsumed, err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"tpaulmyer/algolia/datetree"
+	"tpaulmyer/algolia/null"
+)
+
+// Bounds and defaults of the parameters of the popular stream.
+const (
+	defaultStreamInterval = 5 * time.Second
+	minStreamInterval     = time.Second
+	maxStreamInterval     = time.Hour
+	defaultStreamSize     = 10
+)
+
+// StreamQuery is a query of a popular stream event.
+type StreamQuery struct {
+	Query string `json:"query"`
+	Count int    `json:"count"`
+	// Delta is the change of the count since the previous event of the same
+	// hour.
+	Delta int `json:"delta"`
+}
+
+// StreamEvent is the data of the events sent by the PopularStream route.
+type StreamEvent struct {
+	Hour    string        `json:"hour"`
+	Queries []StreamQuery `json:"queries"`
+}
+
+// PopularStream is the handler responsible for the /1/queries/popular/stream
+// route. It sends the ranking of the most popular queries of the current hour
+// as server-sent events, every interval, until the client disconnects.
+func (h *Handler) PopularStream(w http.ResponseWriter, r *http.Request) {
+	interval, size, err := getStreamParameters(r)
+	if err != nil {
+		h.Error(w, r, err)
+		return
+	}
+	GetRequestInfo(r).Size = null.Int{Valid: true, Int: size}
+
+	rc := http.NewResponseController(w)
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	hdr.Set("X-Accel-Buffering", "no") // disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+
+	// clients reconnecting after a disconnection wait for one interval
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", interval.Milliseconds()); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var hour string
+	var previous map[string]int
+	for id := 1; ; id++ {
+		start := h.now().UTC().Truncate(time.Hour)
+		now := DateInfo{Time: start, To: start.Add(time.Hour), Layout: Hour}
+		if k := GetAPIKey(r); k != nil && !k.AllowsDate(now) {
+			out := APIError{
+				Error:     "API key not allowed to access this date range",
+				Code:      CodeForbidden,
+				Param:     "date",
+				Doc:       errorDocs[CodeForbidden],
+				RequestID: GetRequestInfo(r).ID,
+			}
+			h.sendEvent(w, r, "error", id, out)
+			return
+		}
+
+		if now.Format(Hour) != hour {
+			hour, previous = now.Format(Hour), nil
+		}
+		event := StreamEvent{Hour: hour}
+		event.Queries, previous = streamRanking(h.index(r).Tree(), now, size, previous)
+		if !h.sendEvent(w, r, "popular", id, event) {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			h.RequestLogger(r).Warn("failed to flush stream", "err", err)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.Stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// streamRanking returns the most popular queries of the hour and their counts,
+// with their deltas from the previous counts.
+func streamRanking(tree *datetree.Tree, hour DateInfo, size int, previous map[string]int) ([]StreamQuery, map[string]int) {
+	if tree == nil {
+		return []StreamQuery{}, previous
+	}
+
+	pop := tree.Popular(datetree.Search{
+		Year:       hour.Year(),
+		Month:      null.Int{Valid: true, Int: int(hour.Month())},
+		Day:        null.Int{Valid: true, Int: hour.Day()},
+		Hour:       null.Int{Valid: true, Int: hour.Hour()},
+		Popularity: size,
+	})
+
+	queries := make([]StreamQuery, len(pop))
+	counts := make(map[string]int, len(pop))
+	for i, p := range pop {
+		queries[i] = StreamQuery{Query: p.Query, Count: p.Count, Delta: p.Count - previous[p.Query]}
+		counts[p.Query] = p.Count
+	}
+
+	return queries, counts
+}
+
+// sendEvent writes a server-sent event, reporting whether it succeeded.
+func (h *Handler) sendEvent(w http.ResponseWriter, r *http.Request, event string, id int, data interface{}) bool {
+	d, err := json.Marshal(data)
+	if err != nil {
+		h.RequestLogger(r).Error("failed to marshal event", "err", err)
+		return false
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", event, id, d); err != nil {
+		h.RequestLogger(r).Debug("stream closed", "err", err)
+		return false
+	}
+	return true
+}
+
+// getStreamParameters returns the interval and size parameters of the popular
+// stream.
+func getStreamParameters(r *http.Request) (time.Duration, int, error) {
+	q := r.URL.Query()
+
+	interval := defaultStreamInterval
+	if s := q.Get("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < minStreamInterval || d > maxStreamInterval {
+			return 0, 0, &Error{
+				Status:  http.StatusBadRequest,
+				Code:    CodeInvalidParameter,
+				Param:   "interval",
+				Message: "interval parameter must be a duration between " + minStreamInterval.String() + " and " + maxStreamInterval.String(),
+			}
+		}
+		interval = d
+	}
+
+	size := defaultStreamSize
+	if q.Get("size") != "" {
+		var err error
+		if size, err = GetSizeParameter(r); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return interval, size, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPopularStream(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+		Clock:  &fakeClock{now: time.Date(2015, 8, 22, 0, 30, 0, 0, time.UTC)},
+	}
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.PopularStream(w, r)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/1/queries/popular/stream?interval=1s&size=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("wrong Content-Type %q", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() (string, StreamEvent) {
+		var name string
+		var event StreamEvent
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case line == "" && name != "":
+				return name, event
+			case strings.HasPrefix(line, "event: "):
+				name = line[len("event: "):]
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(line[len("data: "):]), &event); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return "", event
+	}
+	counts := func(event StreamEvent) map[string][2]int {
+		ret := map[string][2]int{}
+		for _, q := range event.Queries {
+			ret[q.Query] = [2]int{q.Count, q.Delta}
+		}
+		return ret
+	}
+
+	name, event := next()
+	if name != "popular" || event.Hour != "2015-08-22 00" {
+		t.Fatalf("wrong first event %s %+v", name, event)
+	}
+	want := map[string][2]int{"yeah": {1, 1}, "will_this_test_succed_?": {1, 1}}
+	if got := counts(event); len(got) != len(want) || got["yeah"] != want["yeah"] || got["will_this_test_succed_?"] != want["will_this_test_succed_?"] {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+
+	// new hits are reported as deltas on the next tick
+	tree := getTreeForTests(t)
+	tree.Insert("yeah", time.Date(2015, 8, 22, 0, 40, 0, 0, time.UTC))
+	tree.Insert("yeah", time.Date(2015, 8, 22, 0, 41, 0, 0, time.UTC))
+	tree.IndexPopularity()
+	h.Index.SetTree(tree)
+
+	_, event = next()
+	got := counts(event)
+	if got["yeah"] != [2]int{3, 2} || got["will_this_test_succed_?"] != [2]int{1, 0} {
+		t.Errorf("wrong counts and deltas %v", got)
+	}
+
+	// the handler returns once the client disconnects
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler should return when the client disconnects")
+	}
+}
+
+func TestPopularStreamParameters(t *testing.T) {
+	h := Handler{
+		Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		Index:  getIndexForTests(t),
+	}
+
+	for _, target := range []string{"?interval=10ms", "?interval=soon", "?size=-1"} {
+		w := httptest.NewRecorder()
+		h.PopularStream(w, httptest.NewRequest("GET", "/1/queries/popular/stream"+target, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), CodeInvalidParameter) {
+			t.Errorf("%s: wanted an invalid parameter error, got %d %s", target, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the timestamps of input files, besides time layouts.
+const (
+	TimeFormatAuto      = "auto"     // detected in each file
+	TimeFormatUnix      = "unix"     // seconds since the epoch, with an optional fraction
+	TimeFormatUnixMilli = "unix_ms"  // milliseconds since the epoch
+	TimeFormatRFC3339   = "rfc3339"  // 2015-08-01T00:03:43+02:00, with optional fractional seconds
+	TimeFormatISOLocal  = "isolocal" // 2015-08-01T00:03:43, in UTC
+)
+
+// autoTimeFormats are the formats detected, in the order they are tried.
+var autoTimeFormats = []string{Second, TimeFormatRFC3339, TimeFormatISOLocal}
+
+// unixMilliDigits is the number of digits from which epoch timestamps are
+// detected as milliseconds: 12 digits of seconds are past year 5000.
+const unixMilliDigits = 12
+
+// validTimeFormat returns an error if format is neither a format of
+// timestamps nor a time layout.
+func validTimeFormat(format string) error {
+	switch format {
+	case TimeFormatAuto, TimeFormatUnix, TimeFormatUnixMilli, TimeFormatRFC3339, TimeFormatISOLocal:
+		return nil
+	}
+	ref := time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC)
+	if ref.Format(format) == format {
+		return fmt.Errorf("invalid time format %q", format)
+	}
+	if _, err := time.Parse(format, ref.Format(format)); err != nil {
+		return fmt.Errorf("invalid time format %q: %v", format, err)
+	}
+	return nil
+}
+
+// timeParser parses the timestamps of a file in a format, detected from the
+// first timestamp of the file with TimeFormatAuto. Timestamps are returned in
+// UTC, the zone of the date tree, those without offset being in UTC.
+type timeParser struct {
+	format string
+	auto   bool // whether the format is detected
+}
+
+func newTimeParser(format string) *timeParser {
+	if format == "" {
+		format = TimeFormatAuto
+	}
+	return &timeParser{format: format, auto: format == TimeFormatAuto}
+}
+
+// newSourceTimeParser returns the parser of the timestamps of a source, which
+// keeps the format detected in the file before the position given.
+func newSourceTimeParser(opts SourceOptions, from Position) *timeParser {
+	if from.TimeFormat != "" && (opts.TimeFormat == "" || opts.TimeFormat == TimeFormatAuto) {
+		return &timeParser{format: from.TimeFormat, auto: true}
+	}
+	return newTimeParser(opts.TimeFormat)
+}
+
+// detected returns the format detected in the file, if any.
+func (p *timeParser) detected() string {
+	if !p.auto || p.format == TimeFormatAuto {
+		return ""
+	}
+	return p.format
+}
+
+func (p *timeParser) parse(s string) (time.Time, error) {
+	if p.format != TimeFormatAuto {
+		return parseTime(p.format, s)
+	}
+
+	format, err := detectTimeFormat(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := parseTime(format, s)
+	if err == nil {
+		p.format = format
+	}
+	return t, err
+}
+
+// detectTimeFormat returns the format of a timestamp.
+func detectTimeFormat(s string) (string, error) {
+	if digits := strings.TrimLeft(s, "-"); digits != "" && strings.Trim(digits, "0123456789.") == "" {
+		if i := strings.IndexByte(digits, '.'); i < 0 && len(digits) >= unixMilliDigits {
+			return TimeFormatUnixMilli, nil
+		}
+		return TimeFormatUnix, nil
+	}
+	for _, format := range autoTimeFormats {
+		if _, err := parseTime(format, s); err == nil {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown time format of %q", s)
+}
+
+// parseTime parses a timestamp in a format, returning it in UTC.
+func parseTime(format, s string) (time.Time, error) {
+	switch format {
+	case TimeFormatUnix:
+		sec, frac, _ := strings.Cut(s, ".")
+		n, err := strconv.ParseInt(sec, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch seconds %q", s)
+		}
+		var nsec int64
+		if frac != "" {
+			if len(frac) > 9 {
+				frac = frac[:9]
+			}
+			if nsec, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil || nsec < 0 {
+				return time.Time{}, fmt.Errorf("invalid epoch seconds %q", s)
+			}
+			if strings.HasPrefix(sec, "-") {
+				nsec = -nsec
+			}
+		}
+		return time.Unix(n, nsec).UTC(), nil
+	case TimeFormatUnixMilli:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch milliseconds %q", s)
+		}
+		return time.UnixMilli(n).UTC(), nil
+	case TimeFormatRFC3339:
+		format = time.RFC3339Nano
+	case TimeFormatISOLocal:
+		format = "2006-01-02T15:04:05.999999999"
+	case TimeFormatAuto, "":
+		return time.Time{}, errors.New("no time format")
+	}
+
+	t, err := time.Parse(format, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
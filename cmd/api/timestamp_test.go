@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeParser(t *testing.T) {
+	tests := []struct {
+		format string
+		in     []string // timestamps of a file
+		want   []string // in RFC 3339, or an empty string for an error
+	}{
+		{TimeFormatAuto, []string{"2015-08-01 00:03:43", "2015-08-01T00:03:43Z"}, []string{"2015-08-01T00:03:43Z", ""}},
+		{TimeFormatAuto, []string{"yesterday", "1438387423", "1438387424.5"}, []string{"", "2015-08-01T00:03:43Z", "2015-08-01T00:03:44.5Z"}},
+		{TimeFormatAuto, []string{"1438387423123", "1438387424000"}, []string{"2015-08-01T00:03:43.123Z", "2015-08-01T00:03:44Z"}},
+		{TimeFormatAuto, []string{"2015-08-01T02:03:43+02:00", "2015-08-01T00:03:44.5Z"}, []string{"2015-08-01T00:03:43Z", "2015-08-01T00:03:44.5Z"}},
+		{TimeFormatAuto, []string{"2015-08-01T00:03:43", "2015-08-01T00:03:44+02:00"}, []string{"2015-08-01T00:03:43Z", ""}},
+		{TimeFormatUnix, []string{"1438387423", "-1.5", "1438387423.25", "1e9"}, []string{"2015-08-01T00:03:43Z", "1969-12-31T23:59:58.5Z", "2015-08-01T00:03:43.25Z", ""}},
+		{TimeFormatUnixMilli, []string{"1438387423123", "1438387423.1"}, []string{"2015-08-01T00:03:43.123Z", ""}},
+		{TimeFormatRFC3339, []string{"2015-07-31T20:03:43-04:00", "2015-08-01 00:03:43"}, []string{"2015-08-01T00:03:43Z", ""}},
+		{"02/01/2006 15h04", []string{"01/08/2015 00h03", "2015-08-01 00:03:43"}, []string{"2015-08-01T00:03:00Z", ""}},
+		{"2006-01-02T15:04:05-0700", []string{"2015-08-01T02:03:43+0200"}, []string{"2015-08-01T00:03:43Z"}},
+	}
+	for _, tc := range tests {
+		p := newTimeParser(tc.format)
+		for i, in := range tc.in {
+			got, err := p.parse(in)
+			if tc.want[i] == "" {
+				if err == nil {
+					t.Errorf("%s: %q should not parse, got %v", tc.format, in, got)
+				}
+				continue
+			}
+			want, _ := time.Parse(time.RFC3339Nano, tc.want[i])
+			if err != nil || !got.Equal(want) || got.Location() != time.UTC {
+				t.Errorf("%s: %q: wanted %v, got %v, %v", tc.format, in, want, got, err)
+			}
+		}
+	}
+}
+
+func TestValidTimeFormat(t *testing.T) {
+	for format, valid := range map[string]bool{
+		TimeFormatAuto:      true,
+		TimeFormatUnixMilli: true,
+		Second:              true,
+		time.RFC1123Z:       true,
+		"":                  false,
+		"epoch":             false,
+		"YYYY-MM-DD":        false,
+	} {
+		if err := validTimeFormat(format); (err == nil) != valid {
+			t.Errorf("%q: wanted valid %v, got %v", format, valid, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML used by configuration files: tables,
+// bare or dotted keys, and values that are strings, integers, floats,
+// booleans or arrays of those. Keys are returned with their table prefix
+// (e.g. "log.level"), and values as raw strings, arrays giving one string per
+// element.
+func parseTOML(r io.Reader) (map[string][]string, error) {
+	ret := map[string][]string{}
+	var table, pending string
+	var pendingLine int
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := stripComment(s.Text())
+		if pending != "" {
+			// continuation of a multi-line array
+			line = pending + " " + line
+		} else {
+			pendingLine = n
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && pending == "" && !strings.Contains(line, "=") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header %s", n, line)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table == "" {
+				return nil, fmt.Errorf("line %d: empty table name", n)
+			}
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+
+		key := strings.TrimSpace(line[:i])
+		raw := strings.TrimSpace(line[i+1:])
+		if strings.HasPrefix(raw, "[") && !arrayClosed(raw) {
+			pending = line
+			continue
+		}
+		pending = ""
+
+		if key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("line %d: invalid key %q", pendingLine, key)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, ok := ret[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %s", pendingLine, key)
+		}
+
+		values, err := parseTOMLValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", pendingLine, key, err)
+		}
+		ret[key] = values
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if pending != "" {
+		return nil, fmt.Errorf("line %d: unterminated array", pendingLine)
+	}
+
+	return ret, nil
+}
+
+// parseTOMLValue parses a single value or an array of values.
+func parseTOMLValue(raw string) ([]string, error) {
+	if !strings.HasPrefix(raw, "[") {
+		v, err := parseTOMLScalar(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []string{v}, nil
+	}
+
+	inner := strings.TrimSpace(raw[1 : len(raw)-1])
+	ret := []string{}
+	for inner != "" {
+		end := scalarEnd(inner)
+		v, err := parseTOMLScalar(strings.TrimSpace(inner[:end]))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+
+		inner = strings.TrimSpace(inner[end:])
+		if inner == "" {
+			break
+		}
+		if inner[0] != ',' {
+			return nil, fmt.Errorf("expected comma in array")
+		}
+		inner = strings.TrimSpace(inner[1:])
+	}
+
+	return ret, nil
+}
+
+func parseTOMLScalar(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, `'`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `'`) || strings.Contains(raw[1:len(raw)-1], `'`) {
+			return "", fmt.Errorf("invalid literal string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+
+	if _, err := strconv.ParseFloat(strings.Replace(raw, "_", "", -1), 64); err != nil {
+		return "", fmt.Errorf("invalid value %s", raw)
+	}
+
+	return strings.Replace(raw, "_", "", -1), nil
+}
+
+// scalarEnd returns the index of the end of the first scalar in s.
+func scalarEnd(s string) int {
+	if s[0] != '"' && s[0] != '\'' {
+		if i := strings.Index(s, ","); i >= 0 {
+			return i
+		}
+		return len(s)
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[0] == '"' && s[i] == '\\':
+			i++
+		case s[i] == s[0]:
+			return i + 1
+		}
+	}
+
+	return len(s)
+}
+
+// stripComment removes a trailing comment, ignoring # characters in strings.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// arrayClosed reports whether the brackets of an array value are balanced.
+func arrayClosed(s string) bool {
+	var depth int
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '[':
+			depth++
+		case quote == 0 && c == ']':
+			depth--
+		}
+	}
+
+	return depth == 0
+}
+
+// tomlString formats a string as a TOML basic string. TOML having no \x or \a
+// escapes, other control characters are escaped as \uXXXX, and invalid UTF-8
+// is replaced by U+FFFD.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
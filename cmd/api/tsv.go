@@ -1,59 +1,135 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
 )
 
+// Kinds of errors found in input files.
+const (
+	IngestErrorSyntax = "syntax" // malformed line, e.g. a bare quote
+	IngestErrorFields = "fields" // wrong number of fields
+	IngestErrorDate   = "date"   // unparseable timestamp
+)
+
+// LineError is an error found on a line of an input file.
+type LineError struct {
+	File string
+	Line int
+	Kind string
+	Err  error
+	Raw  []byte // the rejected line, including its line break
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// TSVOptions configures the parsing of TSV files.
+type TSVOptions struct {
+	// Comma is the field delimiter, a tab if zero, which can be changed to
+	// read other delimiter-separated files such as CSV.
+	Comma rune
+	// LazyQuotes allows quotes in unquoted fields and non-doubled quotes in
+	// quoted fields.
+	LazyQuotes bool
+	// FieldsPerRecord is the number of fields of every line. If negative,
+	// lines can have a variable number of fields; if zero, every line must
+	// have the number of fields of the first one.
+	FieldsPerRecord int
+}
+
+// Record is a line read from a TSV file. Its fields and raw bytes are only
+// valid until the next line is read.
+type Record struct {
+	Line   int
+	Fields []string
+	Raw    []byte // the line as read, including its line break
+}
+
 // TSVReader can be used to read TSV (tab separated values) files.
 type TSVReader struct {
-	reader   *csv.Reader
-	lineChan chan []string
-	errChan  chan error
-}
-
-// NewTSVReader returns a new TSVReader that begins to read lines from the
-// io.Reader passed as parameter. The channels returned by methods Lines and
-// Errors must be consumed afterwards.
-func NewTSVReader(r io.Reader) *TSVReader {
-	ret := &TSVReader{
-		reader:   csv.NewReader(r),
-		lineChan: make(chan []string),
-		errChan:  make(chan error),
-	}
+	File       string // name of the file in errors
+	LineOffset int    // number of lines of the file before the ones read
 
+	reader *csv.Reader
+	input  *recordingReader
+	offset int64 // input offset of the end of the last line read
+}
+
+// NewTSVReader returns a new TSVReader reading lines from the io.Reader
+// passed as parameter.
+func NewTSVReader(file string, r io.Reader, opts TSVOptions) *TSVReader {
+	input := &recordingReader{r: r}
+	ret := &TSVReader{File: file, reader: csv.NewReader(input), input: input}
 	ret.reader.Comma = '\t'
-	go ret.Read()
+	if opts.Comma != 0 {
+		ret.reader.Comma = opts.Comma
+	}
+	ret.reader.LazyQuotes = opts.LazyQuotes
+	ret.reader.FieldsPerRecord = opts.FieldsPerRecord
+	ret.reader.ReuseRecord = true
 	return ret
 }
 
-func (t *TSVReader) Read() {
-	defer func() {
-		close(t.lineChan)
-		close(t.errChan)
-	}()
+// Read returns the next line of the file, or io.EOF once it has been fully
+// read. Malformed lines are returned as a *LineError, after which reading can
+// continue; any other error comes from the underlying reader.
+func (t *TSVReader) Read() (Record, error) {
+	fields, err := t.reader.Read()
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
 
-	for {
-		record, err := t.reader.Read()
-		if err == io.EOF {
-			return
-		}
+	end := t.reader.InputOffset()
+	raw := bytes.TrimLeft(t.input.take(end-t.offset), "\r\n") // skipped empty lines
+	t.offset = end
 
-		if err != nil {
-			t.errChan <- err
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		kind := IngestErrorSyntax
+		if errors.Is(perr.Err, csv.ErrFieldCount) {
+			kind = IngestErrorFields
 		}
-
-		t.lineChan <- record
+		return Record{}, &LineError{File: t.File, Line: t.LineOffset + perr.StartLine, Kind: kind, Err: perr.Err, Raw: append([]byte(nil), raw...)}
 	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	line, _ := t.reader.FieldPos(0)
+	return Record{Line: t.LineOffset + line, Fields: fields, Raw: raw}, nil
+}
+
+// Lines returns the number of line breaks read, which once the file has been
+// fully read is its number of complete lines.
+func (t *TSVReader) Lines() int {
+	return t.input.lines
+}
+
+// recordingReader keeps the bytes read from r until they are taken, for
+// rejected lines to be written back as they were read.
+type recordingReader struct {
+	r     io.Reader
+	buf   bytes.Buffer
+	lines int
 }
 
-// Lines returns a read-only channel that can be used to fetch lines of a TSV.
-func (t *TSVReader) Lines() <-chan []string {
-	return t.lineChan
+func (r *recordingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.buf.Write(b[:n])
+	r.lines += bytes.Count(b[:n], []byte{'\n'})
+	return n, err
 }
 
-// Error returns a read-only channel that can be used to report errors during
-// TSV reading.
-func (t *TSVReader) Error() <-chan error {
-	return t.errChan
+// take returns the next n bytes read.
+func (r *recordingReader) take(n int64) []byte {
+	return r.buf.Next(int(n))
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTSVReader(t *testing.T) {
+	input := "2015-08-03 00:00:07\tElixir\n" +
+		"\n" +
+		"2015-08-03 00:00:08\tonly-two\textra\n" +
+		"2015-08-03 00:00:09\t\"unterminated\n" +
+		"2015-08-03 00:00:10\tba\"re\n" +
+		"2015-08-03 00:00:11\t\"multi\nline\"\n" +
+		"2015-08-03 00:00:12\tlast"
+
+	type result struct {
+		line   int
+		fields []string
+		kind   string // of the error, if any
+		raw    string
+	}
+	read := func(opts TSVOptions) []result {
+		var ret []result
+		r := NewTSVReader("logs.tsv", strings.NewReader(input), opts)
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				return ret
+			}
+			if lerr, ok := err.(*LineError); ok {
+				if lerr.File != "logs.tsv" {
+					t.Errorf("wrong file %s", lerr.File)
+				}
+				ret = append(ret, result{line: lerr.Line, kind: lerr.Kind, raw: string(lerr.Raw)})
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret = append(ret, result{line: rec.Line, fields: append([]string(nil), rec.Fields...), raw: string(rec.Raw)})
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts TSVOptions
+		want []result
+	}{
+		{"strict", TSVOptions{FieldsPerRecord: 2}, []result{
+			{1, []string{"2015-08-03 00:00:07", "Elixir"}, "", "2015-08-03 00:00:07\tElixir\n"},
+			{3, nil, IngestErrorFields, "2015-08-03 00:00:08\tonly-two\textra\n"},
+			// the unterminated quote runs until the bare quote of line 5
+			{4, nil, IngestErrorSyntax, "2015-08-03 00:00:09\t\"unterminated\n2015-08-03 00:00:10\tba\"re\n"},
+			{6, []string{"2015-08-03 00:00:11", "multi\nline"}, "", "2015-08-03 00:00:11\t\"multi\nline\"\n"},
+			{8, []string{"2015-08-03 00:00:12", "last"}, "", "2015-08-03 00:00:12\tlast"},
+		}},
+		{"variable fields", TSVOptions{FieldsPerRecord: -1}, []result{
+			{1, []string{"2015-08-03 00:00:07", "Elixir"}, "", "2015-08-03 00:00:07\tElixir\n"},
+			{3, []string{"2015-08-03 00:00:08", "only-two", "extra"}, "", "2015-08-03 00:00:08\tonly-two\textra\n"},
+			{4, nil, IngestErrorSyntax, "2015-08-03 00:00:09\t\"unterminated\n2015-08-03 00:00:10\tba\"re\n"},
+			{6, []string{"2015-08-03 00:00:11", "multi\nline"}, "", "2015-08-03 00:00:11\t\"multi\nline\"\n"},
+			{8, []string{"2015-08-03 00:00:12", "last"}, "", "2015-08-03 00:00:12\tlast"},
+		}},
+		{"lazy quotes", TSVOptions{FieldsPerRecord: -1, LazyQuotes: true}, []result{
+			{1, []string{"2015-08-03 00:00:07", "Elixir"}, "", "2015-08-03 00:00:07\tElixir\n"},
+			{3, []string{"2015-08-03 00:00:08", "only-two", "extra"}, "", "2015-08-03 00:00:08\tonly-two\textra\n"},
+			// a lazy quoted field runs until a quote followed by a separator
+			{4, []string{"2015-08-03 00:00:09", "unterminated\n2015-08-03 00:00:10\tba\"re\n2015-08-03 00:00:11\t\"multi\nline"}, "", "2015-08-03 00:00:09\t\"unterminated\n2015-08-03 00:00:10\tba\"re\n2015-08-03 00:00:11\t\"multi\nline\"\n"},
+			{8, []string{"2015-08-03 00:00:12", "last"}, "", "2015-08-03 00:00:12\tlast"},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := read(tc.opts); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wanted\n%+v\ngot\n%+v", tc.want, got)
+			}
+		})
+	}
+}
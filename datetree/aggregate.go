@@ -0,0 +1,114 @@
+package datetree
+
+import (
+	"sync"
+	"time"
+)
+
+// ISOWeek identifies an ISO 8601 week, which starts on Monday. The first week
+// of an ISO year is the one containing its first Thursday, so that weeks can
+// cross month and year boundaries.
+type ISOWeek struct {
+	Year int
+	Week int
+}
+
+// AggregateNode is a node aggregating hits that are not contiguous in the
+// calendar hierarchy of the tree, such as the hits of a week or the hits made
+// on Mondays during a year.
+type AggregateNode struct {
+	Hits     Hits
+	PopIndex []Popularity
+}
+
+// insertAggregate inserts a hit in the node at *n, creating it if needed.
+func insertAggregate(n **AggregateNode, address string) {
+	if *n == nil {
+		*n = &AggregateNode{Hits: map[string]int{}}
+	}
+	(*n).Hits[address]++
+}
+
+// count returns the number of distinct queries of a node, which can be nil.
+func (a *AggregateNode) count() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.Hits)
+}
+
+// popular returns the n most popular queries of a node, which can be nil.
+func (a *AggregateNode) popular(n int) []Popularity {
+	if a == nil {
+		return nil
+	}
+	return queryPopularity(n, a.PopIndex)
+}
+
+// insertWeek inserts a hit in the node of its ISO week.
+func (t *Tree) insertWeek(address string, ti time.Time) {
+	year, week := ti.ISOWeek()
+	k := ISOWeek{year, week}
+	w := t.Weeks[k]
+	insertAggregate(&w, address)
+	t.Weeks[k] = w
+}
+
+// indexWeeks indexes the popularity of the weeks of the tree.
+func (t *Tree) indexWeeks() {
+	for _, w := range t.Weeks {
+		w.PopIndex = w.Hits.IndexPopularity()
+	}
+}
+
+// insertCycles inserts a hit in the nodes of its day of the week and hour of
+// the day.
+func (y *YearNode) insertCycles(address string, t time.Time) {
+	insertAggregate(&y.Weekdays[t.Weekday()], address)
+	insertAggregate(&y.HoursOfDay[t.Hour()], address)
+}
+
+// indexCycles indexes the popularity of the days of the week and hours of the
+// day of the year.
+func (y *YearNode) indexCycles() {
+	for _, i := range y.cycleIndexes() {
+		*i.p = i.index
+	}
+}
+
+// cycleIndexes builds the popularity indexes of the days of the week and hours
+// of the day of the year in parallel, without setting them.
+func (y *YearNode) cycleIndexes() []popularityIndex {
+	var ret []popularityIndex
+	for _, nodes := range [][]*AggregateNode{y.Weekdays[:], y.HoursOfDay[:]} {
+		for _, v := range nodes {
+			if v != nil {
+				ret = append(ret, popularityIndex{p: &v.PopIndex, hits: v.Hits})
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range ret {
+		wg.Add(1)
+		go func(i *popularityIndex) {
+			defer wg.Done()
+			i.index = i.hits.IndexPopularity()
+		}(&ret[i])
+	}
+	wg.Wait()
+	return ret
+}
+
+// cycle returns the node of the day of the week or hour of the day searched,
+// and whether one is.
+func (y *YearNode) cycle(s Search) (*AggregateNode, bool) {
+	switch {
+	case s.Weekday.Valid:
+		return y.Weekdays[s.Weekday.Int], true
+	case s.HourOfDay.Valid:
+		return y.HoursOfDay[s.HourOfDay.Int], true
+	default:
+		return nil, false
+	}
+}
@@ -3,24 +3,53 @@ package datetree
 import (
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Tree is a structure that allows to insert and retrieve date-indexed hits from
-// Algolia's HN Search.
+// Algolia's HN Search. Its methods can be called concurrently, hits being
+// inserted while it is searched. Its fields must not be accessed while it is
+// being modified, Stats giving the number of hits then.
 type Tree struct {
 	Years      map[int]*YearNode
+	Weeks      map[ISOWeek]*AggregateNode
 	TotalCount int
+
+	mu       sync.RWMutex
+	refresh  sync.Mutex // serializes RefreshPopularity
+	version  uint64
+	modified time.Time
+	changed  bool // whether hits were inserted since the version changed
+	latest   time.Time
+
+	// seconds that received hits since the popularity was indexed, once it
+	// has been indexed a first time
+	indexed bool
+	dirty   map[time.Time]struct{}
+
+	// forms of the queries inserted with InsertForm, and the most frequent one
+	forms   map[string]map[string]int
+	display map[string]string
 }
 
+// generation is incremented on every mutation of any tree, so that versions are
+// unique among all the trees of a process.
+var generation uint64
+
 // NewTree returns an initialized tree.
 func NewTree() *Tree {
-	return &Tree{Years: map[int]*YearNode{}}
+	return &Tree{Years: map[int]*YearNode{}, Weeks: map[ISOWeek]*AggregateNode{}}
 }
 
 // Insert inserts a new hit from HN search into a Tree.
 func (t *Tree) Insert(address string, ti time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.insert(address, ti)
+}
 
+func (t *Tree) insert(address string, ti time.Time) {
 	year := ti.Year()
 	yn, ok := t.Years[year]
 	if !ok {
@@ -29,11 +58,73 @@ func (t *Tree) Insert(address string, ti time.Time) {
 		t.Years[year] = yn
 	}
 	yn.Insert(address, ti)
+	t.insertWeek(address, ti)
 	t.TotalCount++
+	t.changed = true
+	if ti.After(t.latest) {
+		t.latest = ti
+	}
+	if t.indexed {
+		t.dirty[ti.Truncate(time.Second)] = struct{}{}
+	}
+}
+
+// Version returns the version of the tree, which changes every time hits are
+// indexed, and when hits have been inserted since it was last returned, the
+// insertions between two calls changing it once. Versions are unique among all
+// the trees of a process, a tree built from the same data having a different
+// version.
+func (t *Tree) Version() uint64 {
+	v, _ := t.stamp()
+	return v
+}
+
+// LastModified returns the time the version of the tree last changed.
+func (t *Tree) LastModified() time.Time {
+	_, m := t.stamp()
+	return m
+}
+
+// stamp returns the version of the tree and its modification time, changing
+// them first if hits have been inserted since they last changed.
+func (t *Tree) stamp() (uint64, time.Time) {
+	t.mu.RLock()
+	v, m, changed := t.version, t.modified, t.changed
+	t.mu.RUnlock()
+	if !changed {
+		return v, m
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.changed {
+		t.touch()
+	}
+	return t.version, t.modified
+}
+
+// Latest returns the time of the latest hit inserted, the zero time if the tree
+// is empty.
+func (t *Tree) Latest() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.latest
+}
+
+func (t *Tree) touch() {
+	t.version = atomic.AddUint64(&generation, 1)
+	t.modified = time.Now()
+	t.changed = false
 }
 
 // Count returns the number of hits for a specific date.
 func (t *Tree) Count(s Search) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if s.Week.Valid {
+		return t.Weeks[ISOWeek{s.Year, s.Week.Int}].count()
+	}
+
 	var ret int
 	if y, ok := t.Years[s.Year]; ok && y != nil {
 		ret = y.Count(s)
@@ -45,6 +136,9 @@ func (t *Tree) Count(s Search) int {
 // IndexPopularity creates an index for each node containing information about
 // hit popularity.
 func (t *Tree) IndexPopularity() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	var wg sync.WaitGroup
 	for _, v := range t.Years {
 		wg.Add(1)
@@ -54,24 +148,37 @@ func (t *Tree) IndexPopularity() {
 		}(v)
 	}
 
+	t.indexWeeks()
 	wg.Wait()
+	t.indexed = true
+	t.dirty = map[time.Time]struct{}{}
+	t.touch()
 }
 
 // Popular returns the most popular hits for a specific date.
 func (t *Tree) Popular(s Search) []Popularity {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if s.Week.Valid {
+		return t.displayForms(t.Weeks[ISOWeek{s.Year, s.Week.Int}].popular(s.Popularity))
+	}
+
 	var ret []Popularity
 	if y, ok := t.Years[s.Year]; ok && y != nil {
 		ret = y.Popular(s)
 	}
 
-	return ret
+	return t.displayForms(ret)
 }
 
-// YearNode is a node representing a year.
+// YearNode is a node representing a year. It also aggregates the hits of the
+// year by day of the week and hour of the day.
 type YearNode struct {
-	Months   [12]*MonthNode
-	Hits     Hits
-	PopIndex []Popularity
+	Months     [12]*MonthNode
+	Hits       Hits
+	PopIndex   []Popularity
+	Weekdays   [7]*AggregateNode // by time.Weekday
+	HoursOfDay [24]*AggregateNode
 }
 
 // Insert inserts a new node in a YearNode.
@@ -85,11 +192,15 @@ func (y *YearNode) Insert(address string, t time.Time) {
 	}
 
 	y.Hits[address]++
+	y.insertCycles(address, t)
 	m.Insert(address, t)
 }
 
 // Count returns the number of hits for a specific date.
 func (y *YearNode) Count(s Search) int {
+	if a, ok := y.cycle(s); ok {
+		return a.count()
+	}
 	if !s.Month.Valid {
 		return len(y.Hits)
 	}
@@ -117,11 +228,15 @@ func (y *YearNode) IndexPopularity() {
 	}
 
 	y.PopIndex = y.Hits.IndexPopularity()
+	y.indexCycles()
 	wg.Wait()
 }
 
 // Popular returns the most popular hits for a specific date.
 func (y *YearNode) Popular(s Search) []Popularity {
+	if a, ok := y.cycle(s); ok {
+		return a.popular(s.Popularity)
+	}
 	if !s.Month.Valid {
 		return queryPopularity(s.Popularity, y.PopIndex)
 	}
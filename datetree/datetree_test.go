@@ -2,6 +2,8 @@ package datetree_test
 
 import (
 	"fmt"
+	"reflect"
+	"testing"
 	"time"
 	"tpaulmyer/algolia/datetree"
 	"tpaulmyer/algolia/null"
@@ -30,3 +32,280 @@ func ExampleTree() {
 	// 1
 	// [{https://www.algolia.com/ 2}]
 }
+
+func TestRange(t *testing.T) {
+	type hit struct {
+		query string
+		time  time.Time
+	}
+	var hits []hit
+	tree := datetree.NewTree()
+	start := time.Date(2015, time.December, 30, 22, 58, 0, 0, time.UTC)
+	for i := 0; i < 500; i++ {
+		// spread hits over a few days across a year boundary
+		h := hit{query: fmt.Sprintf("q%d", i%17), time: start.Add(time.Duration(i*i) * time.Second)}
+		hits = append(hits, h)
+		tree.Insert(h.query, h.time)
+	}
+	tree.IndexPopularity()
+
+	ranges := [][2]time.Time{
+		{start, start.Add(time.Second)},
+		{start.Add(90 * time.Second), start.Add(47 * time.Hour)},
+		{time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2015, 12, 31, 1, 0, 0, 0, time.FixedZone("", 2*3600)), time.Date(2016, 1, 1, 3, 0, 0, 0, time.FixedZone("", 2*3600))},
+		{start.Add(-time.Hour), start.Add(time.Hour * 24 * 7)},
+		{start.Add(time.Hour), start.Add(time.Hour)},
+	}
+	for _, r := range ranges {
+		want := map[string]int{}
+		for _, h := range hits {
+			if !h.time.Before(r[0]) && h.time.Before(r[1]) {
+				want[h.query]++
+			}
+		}
+
+		got := tree.Range(r[0], r[1])
+		if !reflect.DeepEqual(map[string]int(got), want) {
+			t.Errorf("%v - %v: wanted %v, got %v", r[0], r[1], want, got)
+		}
+		if n := tree.CountRange(r[0], r[1]); n != len(want) {
+			t.Errorf("%v - %v: wanted %d distinct queries, got %d", r[0], r[1], len(want), n)
+		}
+	}
+
+	// a range matching a node gives the same results as a search
+	s := datetree.Search{Year: 2016, Month: null.Int{Valid: true, Int: 1}, Day: null.Int{Valid: true, Int: 1}, Popularity: 3}
+	from := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got, want := tree.PopularRange(from, from.AddDate(0, 0, 1), 3), tree.Popular(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	type hit struct {
+		query string
+		time  time.Time
+	}
+	var hits []hit
+	tree := datetree.NewTree()
+	start := time.Date(2015, time.December, 20, 7, 0, 0, 0, time.UTC)
+	for i := 0; i < 300; i++ {
+		// a hit every 2h13m, over a month across a year boundary
+		h := hit{query: fmt.Sprintf("q%d", i%11), time: start.Add(time.Duration(i) * 133 * time.Minute)}
+		hits = append(hits, h)
+		tree.Insert(h.query, h.time)
+	}
+	tree.IndexPopularity()
+
+	check := func(t *testing.T, s datetree.Search, match func(time.Time) bool) {
+		want := datetree.Hits{}
+		for _, h := range hits {
+			if match(h.time) {
+				want[h.query]++
+			}
+		}
+
+		if n := tree.Count(s); n != len(want) {
+			t.Errorf("wanted %d distinct queries, got %d", len(want), n)
+		}
+		s.Popularity = 3
+		if got, pop := tree.Popular(s), want.IndexPopularity(); !reflect.DeepEqual(got, pop[:min(3, len(pop))]) {
+			t.Errorf("wanted %v, got %v", pop, got)
+		}
+	}
+
+	for _, w := range []datetree.ISOWeek{{2015, 51}, {2015, 53}, {2016, 1}, {2016, 3}, {2014, 1}} {
+		t.Run(fmt.Sprintf("%d-W%02d", w.Year, w.Week), func(t *testing.T) {
+			s := datetree.Search{Year: w.Year, Week: null.Int{Valid: true, Int: w.Week}}
+			check(t, s, func(ti time.Time) bool {
+				year, week := ti.ISOWeek()
+				return year == w.Year && week == w.Week
+			})
+		})
+	}
+
+	for _, year := range []int{2015, 2016} {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			t.Run(fmt.Sprintf("%d %s", year, d), func(t *testing.T) {
+				s := datetree.Search{Year: year, Weekday: null.Int{Valid: true, Int: int(d)}}
+				check(t, s, func(ti time.Time) bool { return ti.Year() == year && ti.Weekday() == d })
+			})
+		}
+		for hour := 0; hour < 24; hour += 5 {
+			t.Run(fmt.Sprintf("%d %02dh", year, hour), func(t *testing.T) {
+				s := datetree.Search{Year: year, HourOfDay: null.Int{Valid: true, Int: hour}}
+				check(t, s, func(ti time.Time) bool { return ti.Year() == year && ti.Hour() == hour })
+			})
+		}
+	}
+
+	if n := tree.Stats().Weeks; n != 5 {
+		t.Errorf("wanted 5 weeks, got %d", n)
+	}
+}
+
+func TestRefreshPopularity(t *testing.T) {
+	type hit struct {
+		query string
+		time  time.Time
+	}
+	var hits []hit
+	start := time.Date(2015, time.December, 31, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < 400; i++ {
+		hits = append(hits, hit{query: fmt.Sprintf("q%d", i*i%13), time: start.Add(time.Duration(i*i) * time.Second / 4)})
+	}
+
+	want := datetree.NewTree()
+	for _, h := range hits {
+		want.Insert(h.query, h.time)
+	}
+	want.IndexPopularity()
+
+	// the second half of the hits is inserted while the tree is searched
+	tree := datetree.NewTree()
+	for _, h := range hits[:200] {
+		tree.Insert(h.query, h.time)
+	}
+	tree.IndexPopularity()
+	indexed := tree.Version()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, h := range hits[200:] {
+			tree.Insert(h.query, h.time)
+		}
+	}()
+	for n := 0; n < 100; n++ {
+		tree.Popular(datetree.Search{Year: 2016, Popularity: 3})
+		tree.CountRange(start, start.Add(time.Hour))
+		tree.Stats()
+	}
+	<-done
+
+	year := datetree.Search{Year: 2016, Popularity: 5}
+	if got := tree.Popular(year); reflect.DeepEqual(got, want.Popular(year)) {
+		t.Errorf("popularity should not be refreshed yet, got %v", got)
+	}
+	// the counts changed, the insertions changing the version once
+	inserted := tree.Version()
+	if inserted == indexed || tree.Version() != inserted {
+		t.Error("inserting hits should change the version once")
+	}
+	if !tree.RefreshPopularity() || tree.Version() == inserted {
+		t.Error("refreshing the popularity should change the version")
+	}
+	if tree.RefreshPopularity() {
+		t.Error("the popularity should already be refreshed")
+	}
+
+	searches := []datetree.Search{
+		{Year: 2015},
+		{Year: 2016},
+		{Year: 2016, Month: null.Int{Valid: true, Int: 1}},
+		{Year: 2016, Month: null.Int{Valid: true, Int: 1}, Day: null.Int{Valid: true, Int: 1}},
+		{Year: 2016, Month: null.Int{Valid: true, Int: 1}, Day: null.Int{Valid: true, Int: 1}, Hour: null.Int{Valid: true, Int: 1}},
+		{Year: 2016, Month: null.Int{Valid: true, Int: 1}, Day: null.Int{Valid: true, Int: 1}, Hour: null.Int{Valid: true, Int: 1}, Minute: null.Int{Valid: true, Int: 40}},
+		{Year: 2015, Week: null.Int{Valid: true, Int: 53}},
+		{Year: 2016, Weekday: null.Int{Valid: true, Int: int(time.Friday)}},
+		{Year: 2016, HourOfDay: null.Int{Valid: true, Int: 2}},
+	}
+	for _, s := range searches {
+		s.Popularity = 5
+		if got, pop := tree.Popular(s), want.Popular(s); !reflect.DeepEqual(got, pop) {
+			t.Errorf("%+v: wanted %v, got %v", s, pop, got)
+		}
+		if got, n := tree.Count(s), want.Count(s); got != n {
+			t.Errorf("%+v: wanted %d distinct queries, got %d", s, n, got)
+		}
+	}
+}
+
+func TestRefreshPopularityWhileInserting(t *testing.T) {
+	start := time.Date(2015, time.December, 31, 23, 59, 0, 0, time.UTC)
+	hit := func(i int) (string, time.Time) {
+		return fmt.Sprintf("q%d", i*i%11), start.Add(time.Duration(i) * time.Second / 3)
+	}
+
+	want := datetree.NewTree()
+	tree := datetree.NewTree()
+	for i := 0; i < 600; i++ {
+		want.Insert(hit(i))
+	}
+	want.IndexPopularity()
+	tree.IndexPopularity()
+
+	// hits are inserted while the popularity is refreshed and searched, the
+	// hits inserted during a refresh being indexed by the next one
+	inserted := make(chan struct{})
+	go func() {
+		defer close(inserted)
+		for i := 0; i < 600; i++ {
+			tree.Insert(hit(i))
+		}
+	}()
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		for {
+			select {
+			case <-inserted:
+				return
+			default:
+				tree.RefreshPopularity()
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-refreshed:
+			running = false
+		default:
+			tree.Popular(datetree.Search{Year: 2016, Popularity: 3})
+		}
+	}
+	tree.RefreshPopularity()
+
+	for _, s := range []datetree.Search{
+		{Year: 2015},
+		{Year: 2016},
+		{Year: 2016, Month: null.Int{Valid: true, Int: 1}, Day: null.Int{Valid: true, Int: 1}, Hour: null.Int{Valid: true, Int: 0}, Minute: null.Int{Valid: true, Int: 1}},
+		{Year: 2016, Week: null.Int{Valid: true, Int: 53}},
+		{Year: 2016, Weekday: null.Int{Valid: true, Int: int(time.Friday)}},
+	} {
+		s.Popularity = 5
+		if got, pop := tree.Popular(s), want.Popular(s); !reflect.DeepEqual(got, pop) {
+			t.Errorf("%+v: wanted %v, got %v", s, pop, got)
+		}
+	}
+}
+
+func TestInsertForm(t *testing.T) {
+	tree := datetree.NewTree()
+	at := time.Date(2015, time.August, 1, 0, 3, 43, 0, time.UTC)
+	for _, form := range []string{"Elixir", "elixir ", "ELIXIR", "elixir ", "Elixir"} {
+		tree.InsertForm("elixir", form, at)
+	}
+	tree.InsertForm("go", "Go", at)
+	tree.InsertForm("go", "go", at)
+	tree.Insert("rust", at)
+	tree.IndexPopularity()
+
+	// equally frequent forms are resolved to the smallest one
+	want := []datetree.Popularity{{Query: "Elixir", Count: 5}, {Query: "Go", Count: 2}, {Query: "rust", Count: 1}}
+	day := datetree.Search{Year: 2015, Month: null.Int{Valid: true, Int: 8}, Day: null.Int{Valid: true, Int: 1}, Popularity: 10}
+	if got := tree.Popular(day); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+	if got := tree.PopularRange(at, at.Add(time.Second), 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v in range, got %v", want, got)
+	}
+
+	// the indexes keep the normalized queries
+	if n := tree.Stats().Queries; n != 3 {
+		t.Errorf("wanted 3 queries, got %d", n)
+	}
+	if hits := tree.Range(at, at.Add(time.Second)); hits["elixir"] != 5 {
+		t.Errorf("wanted elixir to be counted 5 times, got %v", hits)
+	}
+}
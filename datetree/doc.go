@@ -1,6 +1,9 @@
 // Package datetree provide a B-tree like data structure specialized for dates up
 // to the second. It allows to insert new data and to peform searches efficiently.
-// A date tree must be first filled with the data to be indexed before being used.
-// The tree can also index queries by popularity by calling the IndexPopularity
-// method.
+// A date tree is first filled with the data to be indexed, then can index queries
+// by popularity by calling the IndexPopularity method. Hits can still be inserted
+// while the tree is searched, RefreshPopularity updating the popularity of the
+// nodes they were inserted in. Next to the calendar levels, hits are aggregated
+// by ISO week, and by day of the week and hour of the day within each year.
+// Queries inserted with InsertForm are returned in their most frequent form.
 package datetree
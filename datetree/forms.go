@@ -0,0 +1,46 @@
+package datetree
+
+import "time"
+
+// InsertForm inserts a hit like Insert, address being the normalized query and
+// form the string it was written as. Popular and PopularRange return the most
+// frequent form of the queries inserted this way, the smallest one among
+// equally frequent forms.
+func (t *Tree) InsertForm(address, form string, ti time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.insert(address, ti)
+	if t.forms == nil {
+		t.forms = map[string]map[string]int{}
+		t.display = map[string]string{}
+	}
+	counts, ok := t.forms[address]
+	if !ok {
+		counts = map[string]int{}
+		t.forms[address] = counts
+	}
+	counts[form]++
+
+	best, ok := t.display[address]
+	if !ok || counts[form] > counts[best] || (counts[form] == counts[best] && form < best) {
+		t.display[address] = form
+	}
+}
+
+// displayForms returns the popularities with the most frequent form of their
+// queries, p being left unchanged.
+func (t *Tree) displayForms(p []Popularity) []Popularity {
+	if len(t.display) == 0 || len(p) == 0 {
+		return p
+	}
+
+	ret := make([]Popularity, len(p))
+	for i, v := range p {
+		if form, ok := t.display[v.Query]; ok {
+			v.Query = form
+		}
+		ret[i] = v
+	}
+	return ret
+}
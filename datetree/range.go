@@ -0,0 +1,125 @@
+package datetree
+
+import "time"
+
+// CountRange returns the number of distinct queries made between from
+// (inclusive) and to (exclusive).
+func (t *Tree) CountRange(from, to time.Time) int {
+	return len(t.Range(from, to))
+}
+
+// PopularRange returns the n most popular queries made between from
+// (inclusive) and to (exclusive).
+func (t *Tree) PopularRange(from, to time.Time, n int) []Popularity {
+	pop := queryPopularity(n, t.Range(from, to).IndexPopularity())
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.displayForms(pop)
+}
+
+// Range returns the hits made between from (inclusive) and to (exclusive),
+// merging the hits of the largest nodes covered by the range. Times are
+// compared in UTC, the time zone of the tree, at the precision of a second.
+func (t *Tree) Range(from, to time.Time) Hits {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	from, to = from.UTC(), to.UTC()
+	ret := Hits{}
+	for year, y := range t.Years {
+		start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		if y != nil && overlaps(from, to, start, start.AddDate(1, 0, 0)) {
+			y.addRange(ret, start, from, to)
+		}
+	}
+
+	return ret
+}
+
+func (y *YearNode) addRange(ret Hits, start, from, to time.Time) {
+	if covers(from, to, start, start.AddDate(1, 0, 0)) {
+		ret.add(y.Hits)
+		return
+	}
+
+	for i, m := range y.Months {
+		ms := start.AddDate(0, i, 0)
+		if m != nil && overlaps(from, to, ms, ms.AddDate(0, 1, 0)) {
+			m.addRange(ret, ms, from, to)
+		}
+	}
+}
+
+func (m *MonthNode) addRange(ret Hits, start, from, to time.Time) {
+	if covers(from, to, start, start.AddDate(0, 1, 0)) {
+		ret.add(m.Hits)
+		return
+	}
+
+	for i, d := range m.Days {
+		ds := start.AddDate(0, 0, i)
+		if d != nil && overlaps(from, to, ds, ds.AddDate(0, 0, 1)) {
+			d.addRange(ret, ds, from, to)
+		}
+	}
+}
+
+func (d *DayNode) addRange(ret Hits, start, from, to time.Time) {
+	if covers(from, to, start, start.AddDate(0, 0, 1)) {
+		ret.add(d.Hits)
+		return
+	}
+
+	for i, h := range d.Hours {
+		hs := start.Add(time.Duration(i) * time.Hour)
+		if h != nil && overlaps(from, to, hs, hs.Add(time.Hour)) {
+			h.addRange(ret, hs, from, to)
+		}
+	}
+}
+
+func (h *HourNode) addRange(ret Hits, start, from, to time.Time) {
+	if covers(from, to, start, start.Add(time.Hour)) {
+		ret.add(h.Hits)
+		return
+	}
+
+	for i, m := range h.Minutes {
+		ms := start.Add(time.Duration(i) * time.Minute)
+		if m != nil && overlaps(from, to, ms, ms.Add(time.Minute)) {
+			m.addRange(ret, ms, from, to)
+		}
+	}
+}
+
+func (m *MinuteNode) addRange(ret Hits, start, from, to time.Time) {
+	if covers(from, to, start, start.Add(time.Minute)) {
+		ret.add(m.Hits)
+		return
+	}
+
+	// seconds are the smallest unit, included if they start in the range
+	for i, s := range m.Seconds {
+		ss := start.Add(time.Duration(i) * time.Second)
+		if s != nil && !ss.Before(from) && ss.Before(to) {
+			ret.add(s.Hits)
+		}
+	}
+}
+
+// add adds the counts of other to h.
+func (h Hits) add(other Hits) {
+	for q, n := range other {
+		h[q] += n
+	}
+}
+
+// covers reports whether [from, to) contains [start, end).
+func covers(from, to, start, end time.Time) bool {
+	return !start.Before(from) && !end.After(to)
+}
+
+// overlaps reports whether [from, to) and [start, end) intersect.
+func overlaps(from, to, start, end time.Time) bool {
+	return start.Before(to) && end.After(from)
+}
@@ -0,0 +1,74 @@
+package datetree
+
+import "time"
+
+// popularityIndex is the popularity index of the hits of a node, built while
+// the tree is searched and set afterwards.
+type popularityIndex struct {
+	p     *[]Popularity
+	hits  Hits
+	index []Popularity
+}
+
+// RefreshPopularity indexes the popularity of the nodes that received hits
+// since the popularity was last indexed, which is cheaper than IndexPopularity
+// when hits keep being inserted in a large tree. The tree must have been
+// indexed with IndexPopularity before. It reports whether nodes were indexed.
+//
+// The indexes are built while the tree can still be searched, and only swapped
+// in once built. The hits inserted meanwhile are indexed by the next refresh.
+func (t *Tree) RefreshPopularity() bool {
+	t.refresh.Lock()
+	defer t.refresh.Unlock()
+
+	t.mu.Lock()
+	dirty := t.dirty
+	if len(dirty) == 0 {
+		t.mu.Unlock()
+		return false
+	}
+	t.dirty = map[time.Time]struct{}{}
+	t.mu.Unlock()
+
+	t.mu.RLock()
+	var indexes []popularityIndex
+	// nodes shared by several seconds are indexed once
+	done := map[interface{}]bool{}
+	index := func(node interface{}, h Hits, p *[]Popularity) {
+		if !done[node] {
+			done[node] = true
+			indexes = append(indexes, popularityIndex{p: p, hits: h, index: h.IndexPopularity()})
+		}
+	}
+
+	for ti := range dirty {
+		y := t.Years[ti.Year()]
+		if !done[y] {
+			indexes = append(indexes, y.cycleIndexes()...)
+		}
+		index(y, y.Hits, &y.PopIndex)
+		m := y.Months[ti.Month()-1]
+		index(m, m.Hits, &m.PopIndex)
+		d := m.Days[ti.Day()-1]
+		index(d, d.Hits, &d.PopIndex)
+		h := d.Hours[ti.Hour()]
+		index(h, h.Hits, &h.PopIndex)
+		mi := h.Minutes[ti.Minute()]
+		index(mi, mi.Hits, &mi.PopIndex)
+		s := mi.Seconds[ti.Second()]
+		index(s, s.Hits, &s.PopIndex)
+
+		year, week := ti.ISOWeek()
+		w := t.Weeks[ISOWeek{year, week}]
+		index(w, w.Hits, &w.PopIndex)
+	}
+	t.mu.RUnlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, i := range indexes {
+		*i.p = i.index
+	}
+	t.touch()
+	return true
+}
@@ -7,6 +7,10 @@ import "tpaulmyer/algolia/null"
 // time range. The values being set in this structure should be obtained from
 // a time.Time variable in order to ensure date's validity. Querying an unexisting
 // time value (for example hour 44) will result in a panic.
+//
+// Week searches an ISO 8601 week of the ISO year Year instead of the calendar
+// levels. Weekday and HourOfDay search the hits of a year made on a day of the
+// week or at an hour of the day, Weekday taking precedence if both are set.
 type Search struct {
 	Year       int
 	Month      null.Int
@@ -14,5 +18,8 @@ type Search struct {
 	Hour       null.Int
 	Minute     null.Int
 	Second     null.Int
+	Week       null.Int
+	Weekday    null.Int // time.Weekday
+	HourOfDay  null.Int
 	Popularity int
 }
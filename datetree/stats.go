@@ -0,0 +1,77 @@
+package datetree
+
+// Stats gives information on the size of a Tree.
+type Stats struct {
+	Hits    int // number of hits inserted
+	Queries int // number of distinct queries
+	Years   int
+	Weeks   int
+	Months  int
+	Days    int
+	Hours   int
+	Minutes int
+	Seconds int
+}
+
+// Stats walks the tree and returns information on its size.
+func (t *Tree) Stats() Stats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ret := Stats{Hits: t.TotalCount, Weeks: len(t.Weeks)}
+	queries := map[string]struct{}{}
+	for _, y := range t.Years {
+		ret.Years++
+		for q := range y.Hits {
+			queries[q] = struct{}{}
+		}
+		y.stats(&ret)
+	}
+
+	ret.Queries = len(queries)
+	return ret
+}
+
+func (y *YearNode) stats(s *Stats) {
+	for _, m := range y.Months {
+		if m != nil {
+			s.Months++
+			m.stats(s)
+		}
+	}
+}
+
+func (m *MonthNode) stats(s *Stats) {
+	for _, d := range m.Days {
+		if d != nil {
+			s.Days++
+			d.stats(s)
+		}
+	}
+}
+
+func (d *DayNode) stats(s *Stats) {
+	for _, h := range d.Hours {
+		if h != nil {
+			s.Hours++
+			h.stats(s)
+		}
+	}
+}
+
+func (h *HourNode) stats(s *Stats) {
+	for _, m := range h.Minutes {
+		if m != nil {
+			s.Minutes++
+			m.stats(s)
+		}
+	}
+}
+
+func (m *MinuteNode) stats(s *Stats) {
+	for _, sec := range m.Seconds {
+		if sec != nil {
+			s.Seconds++
+		}
+	}
+}
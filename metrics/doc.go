@@ -0,0 +1,4 @@
+// Package metrics implements counters, gauges and histograms that can be
+// exposed to Prometheus using its text exposition format. It only covers what
+// the API needs and has no dependency outside of the standard library.
+package metrics
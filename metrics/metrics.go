@@ -0,0 +1,361 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to measure
+// the latency of HTTP requests.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Registry holds a set of metrics and writes them in the Prometheus text
+// exposition format. Metrics are written in their registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+// NewCounterVec registers a new counter partitioned by the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labels, nil)}
+}
+
+// NewCounter registers a new counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// NewGaugeVec registers a new gauge partitioned by the given labels.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labels, nil)}
+}
+
+// NewGauge registers a new gauge without labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// NewCounterFunc registers a counter without labels whose value is given by f
+// when the metrics are written. f must be safe for concurrent use.
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(name, help, "counter", nil, nil).fn = f
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is given by f when
+// the metrics are written. f must be safe for concurrent use.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(name, help, "gauge", nil, nil).fn = f
+}
+
+// NewHistogramVec registers a new histogram partitioned by the given labels.
+// The buckets must be sorted in increasing order; the +Inf bucket is added
+// automatically.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.register(name, help, "histogram", labels, buckets)}
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+	return f
+}
+
+// WriteTo writes every registered metric to w in the Prometheus text
+// exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, len(r.families))
+	copy(families, r.families)
+	r.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	return cw.n, cw.err
+}
+
+// ServeHTTP exposes the registry on an HTTP route.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	f *family
+}
+
+// With returns the counter for the given label values, creating it if needed.
+// The values must be given in the order the labels were registered.
+func (c *CounterVec) With(values ...string) *Counter {
+	return &Counter{c.f.get(values)}
+}
+
+// Counter is a metric that can only go up.
+type Counter struct {
+	s *series
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v to the counter. Negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+
+	c.s.mu.Lock()
+	c.s.value += v
+	c.s.mu.Unlock()
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	return c.s.value
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	f *family
+}
+
+// With returns the gauge for the given label values, creating it if needed.
+// The values must be given in the order the labels were registered.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{g.f.get(values)}
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	s *series
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.s.mu.Lock()
+	g.s.value = v
+	g.s.mu.Unlock()
+}
+
+// Add adds v to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.s.mu.Lock()
+	g.s.value += v
+	g.s.mu.Unlock()
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	return g.s.value
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	f *family
+}
+
+// With returns the histogram for the given label values, creating it if
+// needed. The values must be given in the order the labels were registered.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{h.f.get(values)}
+}
+
+// Histogram samples observations and counts them in configurable buckets.
+type Histogram struct {
+	s *series
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+
+	i := sort.SearchFloat64s(h.s.bounds, v)
+	h.s.counts[i]++
+	h.s.value += v
+	h.s.count++
+}
+
+// family is a set of series sharing a name, a type and label names.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	fn      func() float64 // value of function metrics
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single time series of a family, identified by its label values.
+type series struct {
+	values []string
+
+	mu     sync.Mutex
+	value  float64 // counter or gauge value, or histogram sum
+	bounds []float64
+	counts []uint64 // histogram only, not cumulative
+	count  uint64   // histogram only
+}
+
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic("metrics: wrong label count for " + f.name)
+	}
+
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.bounds = f.buckets
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+func (f *family) write(w *countWriter) {
+	f.mu.Lock()
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	f.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].values, "\xff") < strings.Join(list[j].values, "\xff")
+	})
+
+	w.write("# HELP ", f.name, " ", escapeHelp(f.help), "\n")
+	w.write("# TYPE ", f.name, " ", f.kind, "\n")
+	if f.fn != nil {
+		w.write(f.name, " ", formatFloat(f.fn()), "\n")
+		return
+	}
+	for _, s := range list {
+		s.mu.Lock()
+		if f.kind != "histogram" {
+			w.write(f.name, f.labelString(s.values, "", ""), " ", formatFloat(s.value), "\n")
+			s.mu.Unlock()
+			continue
+		}
+
+		var cumulative uint64
+		for i, c := range s.counts {
+			cumulative += c
+			le := math.Inf(1)
+			if i < len(s.bounds) {
+				le = s.bounds[i]
+			}
+			w.write(f.name, "_bucket", f.labelString(s.values, "le", formatFloat(le)), " ",
+				strconv.FormatUint(cumulative, 10), "\n")
+		}
+		w.write(f.name, "_sum", f.labelString(s.values, "", ""), " ", formatFloat(s.value), "\n")
+		w.write(f.name, "_count", f.labelString(s.values, "", ""), " ", strconv.FormatUint(s.count, 10), "\n")
+		s.mu.Unlock()
+	}
+}
+
+// labelString formats label pairs, adding an extra pair if name is not empty.
+func (f *family) labelString(values []string, name, value string) string {
+	if len(values) == 0 && name == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, f.labels[i]+`="`+escapeLabel(v)+`"`)
+	}
+	if name != "" {
+		pairs = append(pairs, name+`="`+value+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// countWriter writes strings to a buffered writer, keeping track of the
+// number of bytes written and of the first error encountered.
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) write(parts ...string) {
+	for _, p := range parts {
+		if c.err != nil {
+			return
+		}
+		n, err := c.w.WriteString(p)
+		c.n += int64(n)
+		c.err = err
+	}
+}
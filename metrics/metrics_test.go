@@ -0,0 +1,85 @@
+package metrics_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"tpaulmyer/algolia/metrics"
+)
+
+func ExampleRegistry() {
+	r := metrics.NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Number of requests.", "route")
+	requests.With("/count").Inc()
+	requests.With("/count").Inc()
+	requests.With("/popular").Inc()
+	r.NewGauge("ready", "Whether the API is ready.").Set(1)
+
+	_, _ = r.WriteTo(os.Stdout)
+	// Output: # HELP requests_total Number of requests.
+	// # TYPE requests_total counter
+	// requests_total{route="/count"} 2
+	// requests_total{route="/popular"} 1
+	// # HELP ready Whether the API is ready.
+	// # TYPE ready gauge
+	// ready 1
+}
+
+func TestHistogram(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.5, 1}, "code")
+	h.With("200").Observe(0.25)
+	h.With("200").Observe(0.5)
+	h.With("200").Observe(3)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{code="200",le="0.5"} 2
+latency_seconds_bucket{code="200",le="1"} 2
+latency_seconds_bucket{code="200",le="+Inf"} 3
+latency_seconds_sum{code="200"} 3.75
+latency_seconds_count{code="200"} 3
+`
+	if b.String() != want {
+		t.Errorf("wanted:\n%s\ngot:\n%s", want, b.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounterVec("c", "Help with \\ and\nnewline.", "l").With("a\"b\\c\nd").Inc()
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`# HELP c Help with \\ and\nnewline.`, `c{l="a\"b\\c\nd"} 1`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output should contain %s, got:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestFuncMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	var n float64
+	r.NewCounterFunc("hits_total", "Hits.", func() float64 { return n })
+	r.NewGaugeFunc("size_bytes", "Size.", func() float64 { return 2 * n })
+	n = 21
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# TYPE hits_total counter\nhits_total 21\n", "# TYPE size_bytes gauge\nsize_bytes 42\n"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output should contain %q, got:\n%s", want, b.String())
+		}
+	}
+}